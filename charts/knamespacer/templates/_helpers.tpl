@@ -60,3 +60,29 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+
+{{/*
+Whether to elect a leader. Required when running more than one replica
+*/}}
+{{- define "knamespacer.leaderElection" -}}
+{{- if or .Values.leaderElection.enabled (gt (int .Values.replicaCount) 1) }}true{{- end }}
+{{- end }}
+
+{{/*
+Whether any webhook is enabled
+*/}}
+{{- define "knamespacer.webhooks" -}}
+{{- if or .Values.deletionProtection .Values.metadataEnforcement .Values.metadataOnCreate }}true{{- end }}
+{{- end }}
+
+{{/*
+Name of the webhook Service and of the Secret with its serving certificate
+*/}}
+{{- define "knamespacer.webhookServiceName" -}}
+{{- printf "%s-webhook" (include "knamespacer.fullname" .) | trunc 63 | trimSuffix "-" }}
+{{- end }}
+
+{{- define "knamespacer.webhookSecretName" -}}
+{{- printf "%s-webhook-tls" (include "knamespacer.fullname" .) | trunc 63 | trimSuffix "-" }}
+{{- end }}
@@ -0,0 +1,107 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ejether/knamespacer/pkg/controller"
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/ejether/knamespacer/pkg/kube"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd shows what the controller would change in the cluster
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what Knamespacer would change in the cluster",
+	Long: `Connect to the cluster and show, without changing anything, the annotations and labels
+Knamespacer would change and the namespaces it would create for the --config files.`,
+	Run: Diff,
+}
+
+var diffOutput string
+var diffExitCode bool
+
+func init() {
+	diffCmd.Flags().StringVarP(&diffOutput, "output", "o", "text", "Output format. One of: text, json")
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "Exit with status 2 if there are changes")
+	RootCmd.AddCommand(diffCmd)
+}
+
+func Diff(cmd *cobra.Command, args []string) {
+	if len(configFiles) == 0 {
+		log.Fatal("required flag \"config\" not set")
+	}
+	if diffOutput != "text" && diffOutput != "json" {
+		log.Fatalf("unsupported output format %q", diffOutput)
+	}
+
+	// Keep stdout for the diff itself
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.WarnLevel)
+	}
+
+	nspcCfg, err := knamespace.GetNamespacesConfig(configFiles...)
+	if err != nil {
+		log.Fatalf("unable to retrieve a valid config: %s", err)
+	}
+
+	plan, err := controller.PlanNamespaces(kube.NewK8sClient(), nspcCfg)
+	if err != nil {
+		log.Fatalf("unable to compute changes: %s", err)
+	}
+
+	if diffOutput == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plan); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		printPlan(plan)
+	}
+
+	if diffExitCode && plan.HasChanges() {
+		os.Exit(2)
+	}
+}
+
+func printPlan(plan *controller.Plan) {
+	if len(plan.Create) > 0 {
+		fmt.Println("Namespaces to create:")
+		for _, namespaceName := range plan.Create {
+			fmt.Printf("  + %s\n", namespaceName)
+		}
+		fmt.Println()
+	}
+
+	for _, change := range plan.Changes {
+		fmt.Print(change.Diff)
+	}
+
+	if !plan.HasChanges() {
+		fmt.Println("No changes")
+	}
+}
@@ -18,15 +18,22 @@
 package cmd
 
 import (
+	"context"
 	"os"
+	"time"
 
+	"github.com/ejether/knamespacer/pkg/apis/v1alpha1"
 	"github.com/ejether/knamespacer/pkg/controller"
 	"github.com/ejether/knamespacer/pkg/knamespace"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
@@ -54,20 +61,60 @@ func Execute() {
 	}
 }
 
-var configFile string
+var configFiles []string
 var debug bool
+var dryRun bool
+var forceConflicts bool
+var namespacePolicies bool
+var configConfigMap string
+var leaderElect bool
+var leaderElectionNamespace string
+var leaderElectionID string
+var leaseDuration time.Duration
+var renewDeadline time.Duration
+var retryPeriod time.Duration
+var deletionProtection bool
+var enforceMetadata bool
+var metadataOnCreate bool
+var webhookPort int
+var webhookCertDir string
 
 func init() {
-	RootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Yaml file with Namespaces to configure")
-	err := RootCmd.MarkPersistentFlagRequired("config")
-	if err != nil {
-		log.Fatal(err)
-	}
-
+	RootCmd.PersistentFlags().StringArrayVarP(&configFiles, "config", "c", nil, "Yaml file with Namespaces to configure, or a directory of them. Can be repeated")
 	RootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enable debug mode")
+	RootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Reconcile as usual but only log and report metrics for changes instead of making them")
+	RootCmd.Flags().BoolVar(&forceConflicts, "force-conflicts", false, "Take ownership of annotations and labels other field managers own instead of failing with a conflict, even of keys the config doesn't enforce")
+	RootCmd.Flags().StringVar(&configConfigMap, "config-configmap", "", "Read the config from a ConfigMap through the API instead of --config, as namespace/name or namespace/name:key")
+	RootCmd.Flags().BoolVar(&leaderElect, "leader-elect", false, "Elect a leader with a Lease so several replicas can run. Only the leader reconciles")
+	RootCmd.Flags().StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace of the leader election Lease. Defaults to the namespace knamespacer runs in")
+	RootCmd.Flags().StringVar(&leaderElectionID, "leader-election-id", "knamespacer-leader", "Name of the leader election Lease")
+	RootCmd.Flags().DurationVar(&leaseDuration, "leader-election-lease-duration", 15*time.Second, "How long other replicas wait before taking over leadership from a leader that stopped renewing")
+	RootCmd.Flags().DurationVar(&renewDeadline, "leader-election-renew-deadline", 10*time.Second, "How long the leader keeps trying to renew leadership before giving it up")
+	RootCmd.Flags().DurationVar(&retryPeriod, "leader-election-retry-period", 2*time.Second, "How often replicas try to acquire or renew leadership")
+	RootCmd.Flags().BoolVar(&deletionProtection, "deletion-protection", false, "Serve a validating webhook that denies deleting namespaces whose entry sets preventDeletion")
+	RootCmd.Flags().BoolVar(&enforceMetadata, "enforce-metadata", false, "Serve a validating webhook that rejects namespace creates and updates changing annotations or labels the config enforces")
+	RootCmd.Flags().BoolVar(&metadataOnCreate, "metadata-on-create", false, "Serve a mutating webhook that sets the configured annotations and labels on namespaces as they are created")
+	RootCmd.Flags().IntVar(&webhookPort, "webhook-port", 9443, "Port the webhooks are served on")
+	RootCmd.Flags().StringVar(&webhookCertDir, "webhook-cert-dir", "", "Directory with the webhook serving certificate, tls.crt and tls.key. Defaults to <temp-dir>/k8s-webhook-server/serving-certs")
+	RootCmd.Flags().BoolVar(&namespacePolicies, "namespace-policies", false, "Also configure namespaces with NamespacePolicy resources. Requires the NamespacePolicy CRD")
 }
 
 func Run(cmd *cobra.Command, args []string) {
+	// Not marked required on the flag itself because subcommands don't all need it
+	if len(configFiles) == 0 && configConfigMap == "" && !namespacePolicies {
+		log.Fatal("required flag \"config\" not set")
+	}
+	if len(configFiles) > 0 && configConfigMap != "" {
+		log.Fatal("only one of --config and --config-configmap can be set")
+	}
+	var configMapRef controller.ConfigMapRef
+	if configConfigMap != "" {
+		var err error
+		if configMapRef, err = controller.ParseConfigMapRef(configConfigMap); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	log.SetOutput(os.Stdout)
 	// Set debug logging.
 	if debug {
@@ -81,42 +128,144 @@ func Run(cmd *cobra.Command, args []string) {
 		log.Error(err, "unable to add client-go scheme")
 		os.Exit(1)
 	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		log.Error(err, "unable to add knamespacer scheme")
+		os.Exit(1)
+	}
+
+	// Only the config ConfigMap is cached, which keeps memory use down and RBAC to its namespace
+	cacheOptions := cache.Options{}
+	if configConfigMap != "" {
+		cacheOptions.ByObject = map[client.Object]cache.ByObject{
+			&corev1.ConfigMap{}: {
+				Namespaces: map[string]cache.Config{configMapRef.Namespace: {}},
+				Field:      fields.OneTermEqualSelector("metadata.name", configMapRef.Name),
+			},
+		}
+	}
 
 	// Starting a manager, which handles the connection to the API as well as caching
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		Cache:  cacheOptions,
 		Metrics: metricsserver.Options{
 			BindAddress:   ":8080",
 			SecureServing: false,
 		},
-		WebhookServer:          webhook.NewServer(webhook.Options{}),
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Port:    webhookPort,
+			CertDir: webhookCertDir,
+		}),
 		HealthProbeBindAddress: ":8081",
-		LeaderElection:         false,
+
+		LeaderElection:                leaderElect,
+		LeaderElectionID:              leaderElectionID,
+		LeaderElectionNamespace:       leaderElectionNamespace,
+		LeaderElectionReleaseOnCancel: true,
+		LeaseDuration:                 &leaseDuration,
+		RenewDeadline:                 &renewDeadline,
+		RetryPeriod:                   &retryPeriod,
 	})
 	if err != nil {
 		log.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	// Retrieve the config file once
-	var nspcCfg *knamespace.NamespacesConfig
-	if nspcCfg, err = knamespace.GetNamespacesConfig(configFile); err != nil {
-		log.Error(err, "unable to retrieve")
-		os.Exit(1)
+	// Retrieve the config files once. Refuse to start if they are invalid
+	nspcCfg := knamespace.NewNamespacesConfig("")
+	if len(configFiles) > 0 {
+		if nspcCfg, err = knamespace.GetNamespacesConfig(configFiles...); err != nil {
+			log.Error(err, "unable to retrieve a valid config")
+			os.Exit(1)
+		}
+	}
+	// The cache isn't running yet so the ConfigMap is read from the API directly
+	if configConfigMap != "" {
+		if nspcCfg, err = controller.LoadConfigMap(context.Background(), mgr.GetAPIReader(), configMapRef); err != nil {
+			log.Error(err, "unable to retrieve a valid config")
+			os.Exit(1)
+		}
 	}
 
 	// Register the controller
-	if err = (&controller.KnamespacerController{
+	knamespacerController := &controller.KnamespacerController{
 		StartUp:         true,
 		NamespaceConfig: nspcCfg,
+		DryRun:          dryRun,
+		ForceConflicts:  forceConflicts,
 
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	}
+	if err = knamespacerController.SetupWithManager(mgr); err != nil {
 		log.Error(err, "unable to create controller", "controller", "ImageBuild")
 		os.Exit(1)
 	}
 
+	// Reload the config files whenever they change
+	if len(configFiles) > 0 {
+		if err := mgr.Add(&controller.ConfigReloader{
+			ConfigFiles: configFiles,
+			Controller:  knamespacerController,
+		}); err != nil {
+			log.Error(err, "unable to set up config reloader")
+			os.Exit(1)
+		}
+	}
+
+	// Reload the config ConfigMap as soon as it changes
+	if configConfigMap != "" {
+		configMapReloader := &controller.ConfigMapReloader{
+			Client:     mgr.GetClient(),
+			ConfigMap:  configMapRef,
+			Controller: knamespacerController,
+		}
+		if err = configMapReloader.SetupWithManager(mgr); err != nil {
+			log.Error(err, "unable to set up config ConfigMap reloader")
+			os.Exit(1)
+		}
+	}
+
+	if namespacePolicies {
+		policyController := &controller.NamespacePolicyController{
+			Client:     mgr.GetClient(),
+			Controller: knamespacerController,
+		}
+		if err = policyController.SetupWithManager(mgr); err != nil {
+			log.Error(err, "unable to create NamespacePolicy controller")
+			os.Exit(1)
+		}
+	}
+
+	// The webhook server only starts once a webhook is registered
+	if deletionProtection {
+		deletionWebhook := &controller.DeletionProtectionWebhook{
+			Controller: knamespacerController,
+		}
+		if err = deletionWebhook.SetupWebhookWithManager(mgr); err != nil {
+			log.Error(err, "unable to set up deletion protection webhook")
+			os.Exit(1)
+		}
+	}
+	if enforceMetadata {
+		enforcementWebhook := &controller.MetadataEnforcementWebhook{
+			Controller: knamespacerController,
+		}
+		if err = enforcementWebhook.SetupWebhookWithManager(mgr); err != nil {
+			log.Error(err, "unable to set up metadata enforcement webhook")
+			os.Exit(1)
+		}
+	}
+	if metadataOnCreate {
+		creationWebhook := &controller.CreationMetadataWebhook{
+			Controller: knamespacerController,
+		}
+		if err = creationWebhook.SetupWebhookWithManager(mgr); err != nil {
+			log.Error(err, "unable to set up creation metadata webhook")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		log.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -125,8 +274,17 @@ func Run(cmd *cobra.Command, args []string) {
 		log.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if deletionProtection || enforceMetadata || metadataOnCreate {
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			log.Error(err, "unable to set up webhook ready check")
+			os.Exit(1)
+		}
+	}
 
 	// Manager Starts the Controller
+	if dryRun {
+		log.Info("dry run mode enabled, no changes will be made to the cluster")
+	}
 	log.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		log.Error(err, "problem running manager")
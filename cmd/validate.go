@@ -0,0 +1,148 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd checks config files without connecting to a cluster
+var validateCmd = &cobra.Command{
+	Use:   "validate [config file...]",
+	Short: "Validate Knamespacer config files",
+	Long: `Validate Knamespacer config files without connecting to a cluster.
+Each argument, a file or a directory of files, is validated as a separate config. The --config
+files and directories are validated together, as the controller would load them.
+Exits non-zero if any config is invalid.`,
+	Run: Validate,
+}
+
+var validateOutput string
+
+func init() {
+	validateCmd.Flags().StringVarP(&validateOutput, "output", "o", "text", "Output format. One of: text, json")
+	RootCmd.AddCommand(validateCmd)
+}
+
+// The findings for one config file
+type validationResult struct {
+	File   string                      `json:"file"`
+	Valid  bool                        `json:"valid"`
+	Errors knamespace.ValidationErrors `json:"errors"`
+}
+
+func Validate(cmd *cobra.Command, args []string) {
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		// Findings are reported below, the loader's own error logs would only repeat them
+		log.SetOutput(io.Discard)
+	}
+
+	if validateOutput != "text" && validateOutput != "json" {
+		fmt.Fprintf(os.Stderr, "unsupported output format %q\n", validateOutput)
+		os.Exit(1)
+	}
+
+	var configs [][]string
+	for _, arg := range args {
+		configs = append(configs, []string{arg})
+	}
+	if len(configFiles) > 0 {
+		configs = append(configs, configFiles)
+	}
+	if len(configs) == 0 {
+		fmt.Fprintln(os.Stderr, "no config files given")
+		os.Exit(1)
+	}
+
+	results := make([]validationResult, 0, len(configs))
+	valid := true
+	for _, paths := range configs {
+		result := validateConfig(paths)
+		valid = valid && result.Valid
+		results = append(results, result)
+	}
+
+	if validateOutput == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		printValidationResults(results)
+	}
+
+	if !valid {
+		os.Exit(1)
+	}
+}
+
+// Load config files through the same path the controller uses and collect their findings
+func validateConfig(paths []string) validationResult {
+	file := strings.Join(paths, ",")
+	result := validationResult{
+		File:   file,
+		Errors: knamespace.ValidationErrors{},
+	}
+
+	_, err := knamespace.GetNamespacesConfig(paths...)
+	if err == nil {
+		result.Valid = true
+		return result
+	}
+
+	var validationErrs knamespace.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		result.Errors = validationErrs
+	} else {
+		result.Errors = knamespace.ValidationErrors{{File: file, Message: err.Error()}}
+	}
+	return result
+}
+
+func printValidationResults(results []validationResult) {
+	problems := 0
+	invalidFiles := 0
+	for _, result := range results {
+		if result.Valid {
+			fmt.Printf("%s: OK\n", result.File)
+			continue
+		}
+		invalidFiles++
+		for _, err := range result.Errors {
+			problems++
+			fmt.Println(err.Error())
+		}
+	}
+	if problems > 0 {
+		fmt.Printf("\n%d problem(s) found in %d file(s)\n", problems, invalidFiles)
+	}
+}
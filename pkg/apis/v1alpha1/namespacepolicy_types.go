@@ -0,0 +1,159 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package v1alpha1
+
+import (
+	"github.com/ejether/knamespacer/pkg/knamespace"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition types and reasons reported in NamespacePolicyStatus
+const (
+	// The policy is valid and applied to the namespaces it matches
+	ConditionReady = "Ready"
+
+	ReasonApplied = "Applied"
+	ReasonInvalid = "Invalid"
+)
+
+// NamespacePolicySpec mirrors a namespaces entry of the Knamespacer config file
+type NamespacePolicySpec struct {
+	// Exactly one of name, namePattern, nameGlob or namespaceSelector selects the namespaces the policy applies to
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Regular expression matched against namespace names
+	// +optional
+	NamePattern string `json:"namePattern,omitempty"`
+	// Shell glob matched against namespace names
+	// +optional
+	NameGlob string `json:"nameGlob,omitempty"`
+	// Label selector matched against the namespace's existing labels
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Names of profiles from the config file merged into this policy, in order
+	// +optional
+	Inherits []string `json:"inherits,omitempty"`
+
+	// One of insert, managed, sync or upsert
+	// +optional
+	Mode string `json:"mode,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Override mode for the annotations or the labels only
+	// +optional
+	AnnotationsMode string `json:"annotationsMode,omitempty"`
+	// +optional
+	LabelsMode string `json:"labelsMode,omitempty"`
+	// Override the mode of individual annotation and label keys. One of insert or upsert
+	// +optional
+	KeyModes map[string]string `json:"keyModes,omitempty"`
+
+	// Annotation and label keys or globs removed from the namespace in every mode
+	// +optional
+	RemoveAnnotations []string `json:"removeAnnotations,omitempty"`
+	// +optional
+	RemoveLabels []string `json:"removeLabels,omitempty"`
+
+	// Recreate the namespace when it is deleted. Defaults to true and only applies to policies with a name
+	// +optional
+	Recreate *bool `json:"recreate,omitempty"`
+	// Deny deleting the namespaces the policy applies to
+	// +optional
+	PreventDeletion *bool `json:"preventDeletion,omitempty"`
+}
+
+// NamespacePolicyStatus reports whether the policy is applied and where
+type NamespacePolicyStatus struct {
+	// The generation of the spec the status was computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Names of the namespaces the policy currently applies to
+	// +optional
+	MatchedNamespaces []string `json:"matchedNamespaces,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NamespacePolicy sets the annotations and labels of the namespaces it selects, like an entry in the
+// Knamespacer config file
+type NamespacePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespacePolicySpec   `json:"spec,omitempty"`
+	Status NamespacePolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NamespacePolicyList contains a list of NamespacePolicy
+type NamespacePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespacePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespacePolicy{}, &NamespacePolicyList{})
+}
+
+// The namespaces entry the policy stands for
+func (p *NamespacePolicy) NamespaceConfig() knamespace.NamespaceConfig {
+	namespaceConfig := knamespace.NamespaceConfig{
+		Name:              p.Spec.Name,
+		NamePattern:       p.Spec.NamePattern,
+		NameGlob:          p.Spec.NameGlob,
+		Inherits:          p.Spec.Inherits,
+		Mode:              p.Spec.Mode,
+		Annotations:       p.Spec.Annotations,
+		Labels:            p.Spec.Labels,
+		AnnotationsMode:   p.Spec.AnnotationsMode,
+		LabelsMode:        p.Spec.LabelsMode,
+		KeyModes:          p.Spec.KeyModes,
+		RemoveAnnotations: p.Spec.RemoveAnnotations,
+		RemoveLabels:      p.Spec.RemoveLabels,
+		Recreate:          p.Spec.Recreate,
+		PreventDeletion:   p.Spec.PreventDeletion,
+	}
+	if selector := p.Spec.NamespaceSelector; selector != nil {
+		namespaceConfig.NamespaceSelector = &knamespace.LabelSelector{
+			MatchLabels: selector.MatchLabels,
+		}
+		for _, requirement := range selector.MatchExpressions {
+			namespaceConfig.NamespaceSelector.MatchExpressions = append(namespaceConfig.NamespaceSelector.MatchExpressions, knamespace.LabelSelectorRequirement{
+				Key:      requirement.Key,
+				Operator: string(requirement.Operator),
+				Values:   requirement.Values,
+			})
+		}
+	}
+	return namespaceConfig
+}
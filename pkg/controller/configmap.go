@@ -0,0 +1,151 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+
+	log "github.com/sirupsen/logrus"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// A ConfigMap holding Knamespacer config, and optionally the one key in it to read
+type ConfigMapRef struct {
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// Parse a ConfigMap reference of the form namespace/name or namespace/name:key
+func ParseConfigMapRef(ref string) (ConfigMapRef, error) {
+	namespacedName, key, _ := strings.Cut(ref, ":")
+	namespace, name, ok := strings.Cut(namespacedName, "/")
+	if !ok || namespace == "" || name == "" {
+		return ConfigMapRef{}, fmt.Errorf("invalid ConfigMap %q, expected namespace/name or namespace/name:key", ref)
+	}
+	return ConfigMapRef{Namespace: namespace, Name: name, Key: key}, nil
+}
+
+func (c ConfigMapRef) String() string {
+	if c.Key == "" {
+		return c.Namespace + "/" + c.Name
+	}
+	return c.Namespace + "/" + c.Name + ":" + c.Key
+}
+
+// Read and validate the config in a ConfigMap. Without a key every key that looks like a config file
+// (*.yaml, *.yml or *.json) is read, like the files in a config directory.
+func LoadConfigMap(ctx context.Context, reader client.Reader, ref ConfigMapRef) (*knamespace.NamespacesConfig, error) {
+	configMap := &corev1.ConfigMap{}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, configMap); err != nil {
+		return nil, err
+	}
+	contents, err := configMapContents(configMap, ref)
+	if err != nil {
+		return nil, err
+	}
+	return knamespace.ParseNamespacesConfig(contents...)
+}
+
+// The config held in a ConfigMap, in key order
+func configMapContents(configMap *corev1.ConfigMap, ref ConfigMapRef) ([]knamespace.ConfigContents, error) {
+	var keys []string
+	if ref.Key != "" {
+		if _, ok := configMap.Data[ref.Key]; !ok {
+			return nil, fmt.Errorf("ConfigMap %s/%s has no key %s", ref.Namespace, ref.Name, ref.Key)
+		}
+		keys = []string{ref.Key}
+	} else {
+		for key := range configMap.Data {
+			if knamespace.IsConfigFile(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no config files found in ConfigMap %s/%s", ref.Namespace, ref.Name)
+	}
+
+	contents := make([]knamespace.ConfigContents, 0, len(keys))
+	for _, key := range keys {
+		contents = append(contents, knamespace.ConfigContents{
+			Source:   ConfigMapRef{Namespace: ref.Namespace, Name: ref.Name, Key: key}.String(),
+			Contents: []byte(configMap.Data[key]),
+		})
+	}
+	return contents, nil
+}
+
+// Watches a ConfigMap through the manager's cache and hands every valid new version of the config in it to
+// the controller as soon as it changes. Invalid versions are logged and the last good config is kept.
+type ConfigMapReloader struct {
+	client.Client
+	ConfigMap  ConfigMapRef
+	Controller *KnamespacerController
+}
+
+func (c *ConfigMapReloader) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	namespacesConfig, err := LoadConfigMap(ctx, c.Client, c.ConfigMap)
+	if apierrors.IsNotFound(err) {
+		log.Errorf("Config ConfigMap %s not found, keeping the last good config", c.ConfigMap)
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		log.Errorf("Rejected config ConfigMap %s, keeping the last good config: %s", c.ConfigMap, err)
+		return ctrl.Result{}, nil
+	}
+
+	if reflect.DeepEqual(namespacesConfig, c.Controller.getFileConfig()) {
+		log.Debugf("Config ConfigMap %s unchanged", c.ConfigMap)
+		return ctrl.Result{}, nil
+	}
+
+	log.Infof("Reloading config ConfigMap %s", c.ConfigMap)
+	if err := c.Controller.SetFileConfig(ctx, namespacesConfig); err != nil {
+		return ctrl.Result{}, fmt.Errorf("encounter %w while applying config ConfigMap %s", err, c.ConfigMap)
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the reloader with the Manager. The manager's cache should be limited to the
+// ConfigMap, see cmd.Run. Runs on every replica so the webhooks they serve use the current config.
+func (c *ConfigMapReloader) SetupWithManager(mgr ctrl.Manager) error {
+	needLeaderElection := false
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("configmap").
+		WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(object client.Object) bool {
+			return object.GetNamespace() == c.ConfigMap.Namespace && object.GetName() == c.ConfigMap.Name
+		}))).
+		Complete(c)
+}
@@ -0,0 +1,87 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestParseConfigMapRef(t *testing.T) {
+	ref, err := ParseConfigMapRef("knamespacer/config:namespaces.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, ConfigMapRef{Namespace: "knamespacer", Name: "config", Key: "namespaces.yaml"}, ref)
+	assert.Equal(t, "knamespacer/config:namespaces.yaml", ref.String())
+
+	ref, err = ParseConfigMapRef("knamespacer/config")
+	assert.Nil(t, err)
+	assert.Equal(t, ConfigMapRef{Namespace: "knamespacer", Name: "config"}, ref)
+
+	for _, invalid := range []string{"config", "/config", "knamespacer/", ""} {
+		_, err = ParseConfigMapRef(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestConfigMapReloader(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "knamespacer", Name: "config"},
+		Data: map[string]string{
+			"defaults.yaml": "defaultNamespaceSettings:\n  mode: upsert\n",
+			"teams.yaml":    "namespaces:\n  - name: team-a\n",
+			"README.md":     "not a config file",
+		},
+	}
+	k8s := newFakeClient(configMap)
+	ctx := context.Background()
+
+	ref := ConfigMapRef{Namespace: "knamespacer", Name: "config"}
+	namespacesConfig, err := LoadConfigMap(ctx, k8s, ref)
+	assert.Nil(t, err)
+	assert.Equal(t, "team-a", namespacesConfig.Namespaces[0].Name)
+	assert.Equal(t, "knamespacer/config:teams.yaml", namespacesConfig.EntrySource(0))
+
+	_, err = LoadConfigMap(ctx, k8s, ConfigMapRef{Namespace: "knamespacer", Name: "config", Key: "missing.yaml"})
+	assert.NotNil(t, err)
+
+	r := &KnamespacerController{Client: k8s, NamespaceConfig: namespacesConfig}
+	reloader := &ConfigMapReloader{Client: k8s, ConfigMap: ref, Controller: r}
+
+	// An invalid config is rejected and the last good one kept
+	configMap.Data["teams.yaml"] = "namespaces:\n  - name: team-a\n    mode: bogus\n"
+	assert.Nil(t, k8s.Update(ctx, configMap))
+	_, err = reloader.Reconcile(ctx, ctrl.Request{})
+	assert.Nil(t, err)
+	assert.Equal(t, namespacesConfig, r.GetNamespaceConfig())
+
+	configMap.Data["teams.yaml"] = "namespaces:\n  - name: team-a\n  - name: team-b\n"
+	assert.Nil(t, k8s.Update(ctx, configMap))
+	_, err = reloader.Reconcile(ctx, ctrl.Request{})
+	assert.Nil(t, err)
+	assert.Len(t, r.GetNamespaceConfig().Namespaces, 2)
+
+	// Newly configured namespaces are created
+	namespace := &corev1.Namespace{}
+	assert.Nil(t, k8s.Get(ctx, client.ObjectKey{Name: "team-b"}, namespace))
+}
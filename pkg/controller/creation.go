@@ -0,0 +1,118 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Where the creation metadata webhook is served
+const CreationMetadataPath = "/mutate-namespace-metadata"
+
+// Mutating webhook that sets the configured annotations and labels on namespaces as they are created, so
+// admission controllers such as Pod Security never see a namespace without them
+type CreationMetadataWebhook struct {
+	Controller *KnamespacerController
+}
+
+func (w *CreationMetadataWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("")
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := json.Unmarshal(req.Object.Raw, namespace); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	namespaceConfig, err := w.Controller.GetNamespaceConfig().GetConfig(namespace.Name, namespace.Labels)
+	if err != nil {
+		return admission.Allowed("")
+	}
+	if err := renderNamespaceConfig(namespaceConfig, namespace); err != nil {
+		// Reconciling the namespace reports the error again once it exists
+		log.Errorf("Unable to render config for namespace %s: %s", namespace.Name, err)
+		return admission.Allowed("")
+	}
+
+	desired := namespace.DeepCopy()
+	ModifyNamespaceMetadata(desired, namespaceConfig)
+	before := namespaceMetadata(namespace)
+	after := namespaceMetadata(desired)
+	if reflect.DeepEqual(before, after) {
+		return admission.Allowed("")
+	}
+
+	if w.Controller.DryRun {
+		diff, err := metadataDiff(namespace.Name, before, after)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		log.Infof("Dry run: would set metadata on new namespace %s:\n%s", namespace.Name, diff)
+		return admission.Allowed("")
+	}
+
+	patched, err := patchMetadata(req.Object.Raw, after)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	log.Infof("Setting metadata on new namespace %s", namespace.Name)
+	return admission.PatchResponseFromRaw(req.Object.Raw, patched)
+}
+
+// Replace the annotations and labels of a raw namespace. The rest of it is kept byte for byte, rather than
+// round tripped through corev1.Namespace, so the patch only touches metadata.
+func patchMetadata(raw []byte, metadata NamespaceMetadata) ([]byte, error) {
+	object := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	objectMeta, _ := object["metadata"].(map[string]interface{})
+	if objectMeta == nil {
+		objectMeta = map[string]interface{}{}
+		object["metadata"] = objectMeta
+	}
+	for key, values := range map[string]map[string]string{
+		"annotations": metadata.Annotations,
+		"labels":      metadata.Labels,
+	} {
+		if len(values) == 0 {
+			delete(objectMeta, key)
+		} else {
+			objectMeta[key] = values
+		}
+	}
+	return json.Marshal(object)
+}
+
+// SetupWebhookWithManager serves the webhook on the Manager's webhook server
+func (w *CreationMetadataWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(CreationMetadataPath, &webhook.Admission{Handler: w})
+	return nil
+}
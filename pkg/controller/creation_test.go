@@ -0,0 +1,75 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestCreationMetadataWebhook(t *testing.T) {
+	w := &CreationMetadataWebhook{Controller: &KnamespacerController{
+		NamespaceConfig: &knamespace.NamespacesConfig{
+			Namespaces: []knamespace.NamespaceConfig{
+				{
+					NameGlob:    "team-*",
+					Mode:        knamespace.ModeUpsert,
+					Labels:      map[string]string{"pod-security.example.com/enforce": "restricted"},
+					Annotations: map[string]string{"owner": "{{ .Name }}"},
+				},
+			},
+		},
+	}}
+	ctx := context.Background()
+
+	create := func(name string) admission.Request {
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Name:      name,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"` + name + `","labels":{"app":"ci"}}}`)},
+		}}
+	}
+
+	response := w.Handle(ctx, create("team-a"))
+	assert.True(t, response.Allowed)
+	assert.NotEmpty(t, response.Patches)
+
+	paths := make(map[string]interface{})
+	for _, patch := range response.Patches {
+		paths[patch.Path] = patch.Value
+	}
+	assert.Equal(t, map[string]interface{}{
+		"/metadata/annotations":                              map[string]interface{}{"owner": "team-a"},
+		"/metadata/labels/pod-security.example.com~1enforce": "restricted",
+	}, paths)
+
+	response = w.Handle(ctx, create("other"))
+	assert.True(t, response.Allowed)
+	assert.Empty(t, response.Patches)
+
+	w.Controller.DryRun = true
+	response = w.Handle(ctx, create("team-b"))
+	assert.True(t, response.Allowed)
+	assert.Empty(t, response.Patches)
+}
@@ -0,0 +1,85 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+
+	log "github.com/sirupsen/logrus"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Where the deletion protection webhook is served
+const DeletionProtectionPath = "/validate-namespace-deletion"
+
+// Validating webhook that denies deleting namespaces whose entry sets preventDeletion. Users and groups listed
+// in the config's deletionProtection may delete them, anyone else has to set the allow-deletion annotation first.
+type DeletionProtectionWebhook struct {
+	Controller *KnamespacerController
+}
+
+func (w *DeletionProtectionWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Delete {
+		return admission.Allowed("")
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := json.Unmarshal(req.OldObject.Raw, namespace); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	namespacesConfig := w.Controller.GetNamespaceConfig()
+	namespaceConfig, err := namespacesConfig.GetConfig(namespace.Name, namespace.Labels)
+	if err != nil || !namespaceConfig.GetPreventDeletion() {
+		return admission.Allowed("")
+	}
+
+	if namespace.Annotations[knamespace.AllowDeletionAnnotation] == "true" {
+		log.Infof("Allowing %s to delete protected namespace %s, it has the %s annotation", req.UserInfo.Username, namespace.Name, knamespace.AllowDeletionAnnotation)
+		return admission.Allowed("")
+	}
+	if namespacesConfig.DeletionProtection.Allows(req.UserInfo.Username, req.UserInfo.Groups) {
+		log.Infof("Allowing %s to delete protected namespace %s", req.UserInfo.Username, namespace.Name)
+		return admission.Allowed("")
+	}
+
+	message := fmt.Sprintf("namespace %s is protected from deletion by %s. Set the %s annotation to \"true\" to delete it anyway",
+		namespace.Name, namespacesConfig.DescribeEntry(namespace.Name, namespace.Labels), knamespace.AllowDeletionAnnotation)
+	if w.Controller.DryRun {
+		log.Infof("Dry run: would deny %s deleting namespace %s", req.UserInfo.Username, namespace.Name)
+		return admission.Allowed("").WithWarnings("dry run: " + message)
+	}
+	log.Infof("Denied %s deleting namespace %s", req.UserInfo.Username, namespace.Name)
+	return admission.Denied(message)
+}
+
+// SetupWebhookWithManager serves the webhook on the Manager's webhook server
+func (w *DeletionProtectionWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(DeletionProtectionPath, &webhook.Admission{Handler: w})
+	return nil
+}
@@ -0,0 +1,88 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func deleteRequest(t *testing.T, name string, annotations map[string]string, username string, groups ...string) admission.Request {
+	namespace := testNamespace(nil, annotations)
+	namespace.Name = name
+	raw, err := json.Marshal(namespace)
+	assert.Nil(t, err)
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Name:      name,
+		Operation: admissionv1.Delete,
+		OldObject: runtime.RawExtension{Raw: raw},
+		UserInfo:  authenticationv1.UserInfo{Username: username, Groups: groups},
+	}}
+}
+
+func TestDeletionProtectionWebhook(t *testing.T) {
+	preventDeletion := true
+	w := &DeletionProtectionWebhook{Controller: &KnamespacerController{
+		NamespaceConfig: &knamespace.NamespacesConfig{
+			Namespaces: []knamespace.NamespaceConfig{
+				{Name: "prod", PreventDeletion: &preventDeletion},
+				{Name: "scratch"},
+			},
+			DeletionProtection: knamespace.DeletionProtection{
+				AllowedUsers:  []string{"admin"},
+				AllowedGroups: []string{"platform"},
+			},
+		},
+	}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		req     admission.Request
+		allowed bool
+	}{
+		{"unprotected", deleteRequest(t, "scratch", nil, "someone"), true},
+		{"unconfigured", deleteRequest(t, "other", nil, "someone"), true},
+		{"protected", deleteRequest(t, "prod", nil, "someone", "developers"), false},
+		{"allowed user", deleteRequest(t, "prod", nil, "admin"), true},
+		{"allowed group", deleteRequest(t, "prod", nil, "someone", "developers", "platform"), true},
+		{"annotation", deleteRequest(t, "prod", map[string]string{knamespace.AllowDeletionAnnotation: "true"}, "someone"), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.allowed, w.Handle(ctx, test.req).Allowed)
+		})
+	}
+
+	response := w.Handle(ctx, deleteRequest(t, "prod", nil, "someone"))
+	assert.Contains(t, response.Result.Message, "namespaces[0]")
+	assert.Contains(t, response.Result.Message, knamespace.AllowDeletionAnnotation)
+
+	w.Controller.DryRun = true
+	response = w.Handle(ctx, deleteRequest(t, "prod", nil, "someone"))
+	assert.True(t, response.Allowed)
+	assert.Len(t, response.Warnings, 1)
+}
@@ -0,0 +1,157 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	log "github.com/sirupsen/logrus"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Where the metadata enforcement webhook is served
+const MetadataEnforcementPath = "/validate-namespace-metadata"
+
+// Validating webhook that rejects namespace creates and updates changing annotations or labels in a way the
+// config would undo, instead of reverting them once they are made. Keys the request leaves alone are not
+// checked, so namespaces that haven't been reconciled yet can still be edited. An update is checked against the
+// entry matching the namespace's old labels as well, so a namespace can't escape an entry by dropping the labels
+// that select it together with the keys the entry enforces.
+type MetadataEnforcementWebhook struct {
+	Controller *KnamespacerController
+}
+
+func (w *MetadataEnforcementWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := json.Unmarshal(req.Object.Raw, namespace); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	oldNamespace := &corev1.Namespace{}
+	if req.Operation == admissionv1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, oldNamespace); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	namespacesConfig := w.Controller.GetNamespaceConfig()
+	entries := []map[string]string{namespace.Labels}
+	if req.Operation == admissionv1.Update &&
+		namespacesConfig.MatchingEntry(namespace.Name, oldNamespace.Labels) != namespacesConfig.MatchingEntry(namespace.Name, namespace.Labels) {
+		entries = append(entries, oldNamespace.Labels)
+	}
+
+	var enforced []string
+	for _, labels := range entries {
+		violations := entryViolations(namespacesConfig, labels, oldNamespace, namespace)
+		if len(violations) > 0 {
+			enforced = append(enforced, fmt.Sprintf("%s, as enforced by %s", strings.Join(violations, ", "),
+				namespacesConfig.DescribeEntry(namespace.Name, labels)))
+		}
+	}
+	if len(enforced) == 0 {
+		return admission.Allowed("")
+	}
+
+	message := fmt.Sprintf("namespace %s: %s", namespace.Name, strings.Join(enforced, "; "))
+	if w.Controller.DryRun {
+		log.Infof("Dry run: would reject %s of namespace %s by %s: %s", strings.ToLower(string(req.Operation)), namespace.Name, req.UserInfo.Username, message)
+		return admission.Allowed("").WithWarnings("dry run: " + message)
+	}
+	log.Infof("Rejected %s of namespace %s by %s: %s", strings.ToLower(string(req.Operation)), namespace.Name, req.UserInfo.Username, message)
+	return admission.Denied(message)
+}
+
+// The violations of a request changing oldNamespace to namespace against the config entry matching a namespace
+// with the given labels
+func entryViolations(namespacesConfig *knamespace.NamespacesConfig, labels map[string]string, oldNamespace *corev1.Namespace, namespace *corev1.Namespace) []string {
+	namespaceConfig, err := namespacesConfig.GetConfig(namespace.Name, labels)
+	if err != nil {
+		return nil
+	}
+	if err := renderNamespaceConfig(namespaceConfig, namespace); err != nil {
+		log.Errorf("Unable to render config for namespace %s: %s", namespace.Name, err)
+		return nil
+	}
+
+	desired := namespace.DeepCopy()
+	ModifyNamespaceMetadata(desired, namespaceConfig)
+	before := namespaceMetadata(oldNamespace)
+	requested := namespaceMetadata(namespace)
+	after := namespaceMetadata(desired)
+
+	return append(
+		metadataViolations("label", before.Labels, requested.Labels, after.Labels),
+		metadataViolations("annotation", before.Annotations, requested.Annotations, after.Annotations)...,
+	)
+}
+
+// The keys a request changed, from before to requested, that the config would change again, from requested
+// to after. Described as what the key has to be for the request to be allowed.
+func metadataViolations(kind string, before map[string]string, requested map[string]string, after map[string]string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, metaObject := range []map[string]string{before, requested, after} {
+		for key := range metaObject {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	var violations []string
+	for _, key := range keys {
+		beforeValue, beforeOk := before[key]
+		requestedValue, requestedOk := requested[key]
+		afterValue, afterOk := after[key]
+		if beforeValue == requestedValue && beforeOk == requestedOk {
+			continue
+		}
+		if requestedValue == afterValue && requestedOk == afterOk {
+			continue
+		}
+		if afterOk {
+			violations = append(violations, fmt.Sprintf("%s %s must be %q", kind, key, afterValue))
+		} else {
+			violations = append(violations, fmt.Sprintf("%s %s is not allowed", kind, key))
+		}
+	}
+	return violations
+}
+
+// SetupWebhookWithManager serves the webhook on the Manager's webhook server
+func (w *MetadataEnforcementWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(MetadataEnforcementPath, &webhook.Admission{Handler: w})
+	return nil
+}
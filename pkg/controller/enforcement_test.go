@@ -0,0 +1,102 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func metadataRequest(t *testing.T, name string, oldLabels map[string]string, labels map[string]string) admission.Request {
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Name:      name,
+		Operation: admissionv1.Create,
+	}}
+	namespace := testNamespace(labels, nil)
+	namespace.Name = name
+	raw, err := json.Marshal(namespace)
+	assert.Nil(t, err)
+	req.Object = runtime.RawExtension{Raw: raw}
+
+	if oldLabels != nil {
+		oldNamespace := testNamespace(oldLabels, nil)
+		oldNamespace.Name = name
+		raw, err := json.Marshal(oldNamespace)
+		assert.Nil(t, err)
+		req.Operation = admissionv1.Update
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+	return req
+}
+
+func TestMetadataEnforcementWebhook(t *testing.T) {
+	w := &MetadataEnforcementWebhook{Controller: &KnamespacerController{
+		NamespaceConfig: &knamespace.NamespacesConfig{
+			Namespaces: []knamespace.NamespaceConfig{
+				{Name: "prod", Mode: knamespace.ModeUpsert, Labels: map[string]string{"team": "platform"}},
+				{Name: "locked", Mode: knamespace.ModeSync, Labels: map[string]string{"team": "platform"}},
+				{
+					NamespaceSelector: &knamespace.LabelSelector{MatchLabels: map[string]string{"tier": "restricted"}},
+					Mode:              knamespace.ModeUpsert,
+					Annotations:       map[string]string{"policy": "restricted"},
+					Labels:            map[string]string{"audit": "enabled"},
+				},
+			},
+		},
+	}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		req       admission.Request
+		violation string
+	}{
+		{"change", metadataRequest(t, "prod", map[string]string{"team": "platform"}, map[string]string{"team": "dev"}), `label team must be "platform"`},
+		{"remove", metadataRequest(t, "prod", map[string]string{"team": "platform", "app": "x"}, map[string]string{"app": "x"}), `label team must be "platform"`},
+		{"unrelated key", metadataRequest(t, "prod", map[string]string{"team": "platform"}, map[string]string{"team": "platform", "app": "x"}), ""},
+		{"not reconciled yet", metadataRequest(t, "prod", map[string]string{"app": "x"}, map[string]string{"app": "y"}), ""},
+		{"sync extra key", metadataRequest(t, "locked", map[string]string{"team": "platform"}, map[string]string{"team": "platform", "app": "x"}), "label app is not allowed"},
+		{"create", metadataRequest(t, "prod", nil, map[string]string{"team": "dev"}), `label team must be "platform"`},
+		{"create without keys", metadataRequest(t, "prod", nil, nil), ""},
+		{"leave selected entry", metadataRequest(t, "app", map[string]string{"tier": "restricted", "audit": "enabled"}, map[string]string{}), `label audit must be "enabled"`},
+		{"join selected entry", metadataRequest(t, "app", map[string]string{}, map[string]string{"tier": "restricted", "audit": "enabled"}), ""},
+		{"unconfigured", metadataRequest(t, "other", map[string]string{"team": "platform"}, map[string]string{"team": "dev"}), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := w.Handle(ctx, test.req)
+			assert.Equal(t, test.violation == "", response.Allowed)
+			if test.violation != "" {
+				assert.Contains(t, response.Result.Message, test.violation)
+				assert.Contains(t, response.Result.Message, "namespaces[")
+			}
+		})
+	}
+
+	w.Controller.DryRun = true
+	response := w.Handle(ctx, tests[0].req)
+	assert.True(t, response.Allowed)
+	assert.Len(t, response.Warnings, 1)
+}
@@ -18,68 +18,281 @@
 package controller
 
 import (
+	"fmt"
+	"reflect"
+	"time"
+
 	"github.com/ejether/knamespacer/pkg/knamespace"
 	"github.com/ejether/knamespacer/pkg/kube"
+	"github.com/ejether/knamespacer/pkg/kube/ownership"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
-// Process cluster namespace and modify metadata if specified
-func processNamespace(k8s *kube.K8sClient, namespaceName string, namespacesConfig *knamespace.NamespacesConfig) error {
+// How often to check whether a terminating namespace that is to be recreated is gone
+const terminatingRequeueDelay = 5 * time.Second
+
+// Process cluster namespace and modify metadata if specified. A configured namespace that has been deleted is
+// recreated, one that is still terminating is requeued until it is gone.
+func processNamespace(k8s *kube.K8sClient, namespaceName string, namespacesConfig *knamespace.NamespacesConfig) (ctrl.Result, error) {
 	log.Info("Processing Cluster Namespace: ", namespaceName)
 
-	namespaceConfig, err := namespacesConfig.GetConfig(namespaceName)
+	namespace, err := k8s.GetClusterNamespace(namespaceName)
+	if apierrors.IsNotFound(err) {
+		forgetNamespaceMetrics(namespaceName)
+		return ctrl.Result{}, recreateNamespace(k8s, namespaceName, namespacesConfig)
+	}
+	if err != nil {
+		log.Infof("Unable to fetch cluster namespace '%s' for modification: %s", namespaceName, err)
+		return ctrl.Result{}, err
+	}
+
+	if namespace.DeletionTimestamp != nil {
+		if recreateEntry(namespacesConfig, namespaceName) != nil {
+			log.Infof("Cluster namespace %s is terminating. Waiting to recreate it.", namespaceName)
+			return ctrl.Result{RequeueAfter: terminatingRequeueDelay}, nil
+		}
+		log.Infof("Cluster namespace %s is terminating. Skipping.", namespaceName)
+		return ctrl.Result{}, nil
+	}
+
+	return ctrl.Result{}, updateNamespace(k8s, namespace, namespacesConfig)
+}
+
+// Modify the metadata of an existing namespace to match its config
+func updateNamespace(k8s *kube.K8sClient, namespace *corev1.Namespace, namespacesConfig *knamespace.NamespacesConfig) error {
+	namespaceName := namespace.Name
+
+	// The namespace's current labels decide which namespaceSelector entries apply to it
+	namespaceConfig, err := namespacesConfig.GetConfig(namespaceName, namespace.Labels)
 	if err != nil {
 		log.Infof("No Knamespacer config specified for %s. Skipping.", namespaceName)
 		return nil
 	}
-
-	namespace, err := k8s.GetClusterNamespace(namespaceName)
+	err = renderNamespaceConfig(namespaceConfig, namespace)
 	if err != nil {
-		log.Infof("Unable to fetch cluster namespace '%s' for modification: %s", namespaceName, err)
+		log.Errorf("Unable to render config for namespace %s: %s", namespaceName, err)
 		return err
 	}
 
-	ModifyNamespaceMetadata(namespace, namespaceConfig)
+	desired := namespace.DeepCopy()
+	ModifyNamespaceMetadata(desired, namespaceConfig)
+	before := namespaceMetadata(namespace)
+	after := namespaceMetadata(desired)
+
+	log.Debugf("Updated Namespace Meta: %#v", desired.ObjectMeta)
+
+	recordOutOfPolicyKeys(namespaceName, before, after)
+	enforced := enforcedKeys(namespaceConfig)
+	changed := !reflect.DeepEqual(before, after)
+	// Enforced keys that already have the right value are only applied to take them over, which a dry run can't
+	if !changed && (k8s.DryRun || ownsEnforcedKeys(namespace, after, enforced)) {
+		log.Debugf("Namespace %s already matches its config", namespaceName)
+		return nil
+	}
 
-	log.Debugf("Updated Namespace Meta: %#v", namespace.ObjectMeta)
+	if k8s.DryRun {
+		diff, err := metadataDiff(namespaceName, before, after)
+		if err != nil {
+			return err
+		}
+		log.Infof("Dry run: would update namespace %s:\n%s", namespaceName, diff)
+	}
 
-	err = k8s.UpdateNamespace(namespace)
+	err = k8s.ApplyNamespaceMetadata(namespace, after.Labels, after.Annotations, enforced)
 	if err != nil {
-		log.Errorf("Failed to update namespace %s: %s", namespace, err)
+		log.Errorf("Failed to update namespace %s: %s", namespaceName, err)
+		return err
+	}
+	if !changed {
+		recordNamespaceWrite("take_ownership", k8s.DryRun)
 		return nil
 	}
+	recordNamespaceWrite("update", k8s.DryRun)
+	recordMetadataChanges(namespaceName, before, after, k8s.DryRun)
 
 	return nil
 }
 
+// Recreate a deleted namespace with its configured annotations and labels in a single server-side apply, so it
+// is never seen without them
+func recreateNamespace(k8s *kube.K8sClient, namespaceName string, namespacesConfig *knamespace.NamespacesConfig) error {
+	namespaceConfig := recreateEntry(namespacesConfig, namespaceName)
+	if namespaceConfig == nil {
+		log.Infof("Cluster namespace %s no longer exists. Skipping.", namespaceName)
+		return nil
+	}
+
+	if k8s.DryRun {
+		log.Infof("Dry run: would recreate deleted namespace %s", namespaceName)
+	} else {
+		log.Infof("Recreating deleted namespace %s", namespaceName)
+	}
+	if err := createNamespace(k8s, namespaceName, namespaceConfig); err != nil {
+		log.Errorf("Failed to recreate namespace %s: %s", namespaceName, err)
+		return err
+	}
+	recordNamespaceWrite("create", k8s.DryRun)
+	return nil
+}
+
+// Create a namespace with its configured annotations and labels in a single server-side apply, so it is never
+// seen without them
+func createNamespace(k8s *kube.K8sClient, namespaceName string, namespaceConfig *knamespace.NamespaceConfig) error {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              namespaceName,
+			CreationTimestamp: metav1.Now(),
+		},
+	}
+	if err := renderNamespaceConfig(namespaceConfig, namespace); err != nil {
+		return fmt.Errorf("unable to render config for namespace %s: %w", namespaceName, err)
+	}
+	desired := namespace.DeepCopy()
+	ModifyNamespaceMetadata(desired, namespaceConfig)
+	after := namespaceMetadata(desired)
+
+	return k8s.ApplyNamespaceMetadata(namespace, after.Labels, after.Annotations, enforcedKeys(namespaceConfig))
+}
+
+// The config of a namespace that is recreated when it is deleted, or nil if it isn't. Only an entry with the
+// namespace's exact name recreates it, and only unless it sets recreate to false.
+func recreateEntry(namespacesConfig *knamespace.NamespacesConfig, namespaceName string) *knamespace.NamespaceConfig {
+	i := namespacesConfig.MatchingEntry(namespaceName, nil)
+	if i < 0 || namespacesConfig.Namespaces[i].Name != namespaceName {
+		return nil
+	}
+	namespaceConfig, err := namespacesConfig.GetConfig(namespaceName, nil)
+	if err != nil || !namespaceConfig.GetRecreate() {
+		return nil
+	}
+	return namespaceConfig
+}
+
+// The annotation and label keys whose value a namespace's config enforces: every configured key unless its mode,
+// or the mode of its map, is insert
+func enforcedKeys(namespaceConfig *knamespace.NamespaceConfig) kube.EnforcedKeys {
+	return kube.EnforcedKeys{
+		Labels:      enforcedMetaKeys(namespaceConfig.GetLabelsMode(), namespaceConfig.Labels, namespaceConfig.KeyModes),
+		Annotations: enforcedMetaKeys(namespaceConfig.GetAnnotationsMode(), namespaceConfig.Annotations, namespaceConfig.KeyModes),
+	}
+}
+
+// Whether Knamespacer owns every enforced key the namespace gets through server-side apply. Keys that were set
+// with the right value by anything else, such as the creation webhook, are applied again to take them over.
+func ownsEnforcedKeys(namespace *corev1.Namespace, after NamespaceMetadata, enforced kube.EnforcedKeys) bool {
+	ownedLabels, ownedAnnotations := ownership.ManagedKeys(namespace, kube.FieldManager)
+	return ownsKeys(ownedLabels, after.Labels, enforced.Labels) && ownsKeys(ownedAnnotations, after.Annotations, enforced.Annotations)
+}
+
+func ownsKeys(owned map[string]bool, metaObject map[string]string, enforced map[string]bool) bool {
+	for key := range enforced {
+		if _, ok := metaObject[key]; ok && !owned[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// The keys of the Annotations or Labels config whose value is enforced in a mode
+func enforcedMetaKeys(mode string, config map[string]string, keyModes map[string]string) map[string]bool {
+	enforced := make(map[string]bool)
+	for key := range config {
+		keyMode := mode
+		if m, ok := keyModes[key]; ok {
+			keyMode = m
+		}
+		if keyMode != knamespace.ModeInsert {
+			enforced[key] = true
+		}
+	}
+	return enforced
+}
+
+// Render the templates in a namespace's config against the namespace
+func renderNamespaceConfig(namespaceConfig *knamespace.NamespaceConfig, namespace *corev1.Namespace) error {
+	return namespaceConfig.Render(knamespace.NewTemplateData(namespace.Name, namespace.Labels, namespace.Annotations, namespace.CreationTimestamp.Time))
+}
+
 // Updates Annotation and Label Metadata on the specified namespace according to the NamespaceConfig
 func ModifyNamespaceMetadata(namespace *corev1.Namespace, namespaceConfig *knamespace.NamespaceConfig) {
 	// ModifyNamespaceMetadata(namespace, namespaceConfig)
 	log.Infof("Updating Namespace %s in %s mode", namespace.Name, namespaceConfig.Mode)
 	log.Debugf("Initial Namespace Meta: %#v", namespace.ObjectMeta)
 
-	switch namespaceConfig.Mode {
+	initialAnnotations := copyMeta(namespace.Annotations)
+	initialLabels := copyMeta(namespace.Labels)
+	ownedLabels, ownedAnnotations := ownership.SolelyManagedKeys(namespace, kube.FieldManager)
+
+	namespace.Annotations = modifyNamespaceMeta(namespaceConfig.GetAnnotationsMode(), namespace.Annotations, namespaceConfig.Annotations, ownedAnnotations)
+	namespace.Labels = modifyNamespaceMeta(namespaceConfig.GetLabelsMode(), namespace.Labels, namespaceConfig.Labels, ownedLabels)
+
+	// Per key modes override the mode of the whole map
+	namespace.Annotations = keyModesNamespaceMeta(initialAnnotations, namespace.Annotations, namespaceConfig.Annotations, namespaceConfig.KeyModes)
+	namespace.Labels = keyModesNamespaceMeta(initialLabels, namespace.Labels, namespaceConfig.Labels, namespaceConfig.KeyModes)
+
+	// Removed keys go in every mode
+	namespace.Annotations = removeNamespaceMeta(namespace.Annotations, namespaceConfig.RemoveAnnotations)
+	namespace.Labels = removeNamespaceMeta(namespace.Labels, namespaceConfig.RemoveLabels)
+
+	// Whatever the mode, protected keys are left as they were
+	namespace.Annotations = protectNamespaceMeta(initialAnnotations, namespace.Annotations, namespaceConfig.ProtectedKeys)
+	namespace.Labels = protectNamespaceMeta(initialLabels, namespace.Labels, namespaceConfig.ProtectedKeys)
+	log.Debugf("New Namespace Meta: %#v", namespace.ObjectMeta)
+}
+
+// Apply a mode to the Annotations or Labels of a Namespace
+func modifyNamespaceMeta(mode string, metaObject map[string]string, config map[string]string, owned map[string]bool) map[string]string {
+	switch mode {
 	case "sync":
 		log.Debug("Syncing...")
-		namespace.Annotations = syncNamespaceMeta(namespace.Annotations, namespaceConfig.Annotations)
-		namespace.Labels = syncNamespaceMeta(namespace.Labels, namespaceConfig.Labels)
+		return syncNamespaceMeta(metaObject, config)
 	case "upsert":
 		log.Debug("Upserting...")
-		namespace.Annotations = upsertNamespaceMeta(namespace.Annotations, namespaceConfig.Annotations)
-		namespace.Labels = upsertNamespaceMeta(namespace.Labels, namespaceConfig.Labels)
+		return upsertNamespaceMeta(metaObject, config)
 	case "insert":
 		log.Debug("Inserting...")
-		namespace.Annotations = insertNamespaceMeta(namespace.Annotations, namespaceConfig.Annotations)
-		namespace.Labels = insertNamespaceMeta(namespace.Labels, namespaceConfig.Labels)
+		return insertNamespaceMeta(metaObject, config)
+	case "managed":
+		log.Debug("Managing...")
+		return managedNamespaceMeta(metaObject, config, owned)
 	}
-	log.Debugf("New Namespace Meta: %#v", namespace.ObjectMeta)
+	return metaObject
+}
+
+// Used to apply per key modes to Annotations or Labels on a Namespace after the mode of the whole map has been applied.
+// An insert key keeps its initial value if it had one, an upsert key always gets the configured value.
+func keyModesNamespaceMeta(initial map[string]string, metaObject map[string]string, config map[string]string, keyModes map[string]string) map[string]string {
+	for key, mode := range keyModes {
+		value, ok := config[key]
+		if !ok {
+			continue
+		}
+		if metaObject == nil {
+			metaObject = make(map[string]string)
+		}
+
+		switch mode {
+		case "insert":
+			if initial[key] != "" {
+				metaObject[key] = initial[key]
+			} else {
+				metaObject[key] = value
+			}
+		case "upsert":
+			metaObject[key] = value
+		}
+	}
+	return metaObject
 }
 
-// Used to sync Annotations or Labels on a Namespace. Sync wholesale replaces the meta type so this just returns the new config
-// metaObject passed in so all 'mode' functions have the same signature.
+// Used to sync Annotations or Labels on a Namespace. Sync wholesale replaces the meta type so this just returns a copy of the
+// new config metaObject passed in so all 'mode' functions have the same signature.
 func syncNamespaceMeta(_ map[string]string, config map[string]string) map[string]string {
-	return config
+	return copyMeta(config)
 }
 
 // Use to upsert Annotation or Labels on a Namespace. Upsert replaces any keys that are present with new values and adds new key:values.
@@ -111,14 +324,103 @@ func insertNamespaceMeta(metaObject map[string]string, config map[string]string)
 	return metaObject
 }
 
-// Determine which Knamespaces don't exist in the cluster and create them
-func createMissingNamespaces(k8s *kube.K8sClient, namespacesConfig *knamespace.NamespacesConfig) error {
+// Used to manage Annotations or Labels on a Namespace. Managed upserts the config and removes keys Knamespacer alone
+// owns (i.e. applied earlier) that are no longer in the config. Keys set by anything else are never removed.
+func managedNamespaceMeta(metaObject map[string]string, config map[string]string, owned map[string]bool) map[string]string {
+	for key := range owned {
+		if _, ok := config[key]; !ok {
+			delete(metaObject, key)
+		}
+	}
+	return upsertNamespaceMeta(metaObject, config)
+}
+
+// Used to remove Annotations or Labels from a Namespace whose keys match any of a list of keys or globs
+func removeNamespaceMeta(metaObject map[string]string, removeKeys []string) map[string]string {
+	for key := range metaObject {
+		if knamespace.KeyMatches(removeKeys, key) {
+			delete(metaObject, key)
+		}
+	}
+	return metaObject
+}
+
+// Used to restore protected Annotations or Labels on a Namespace to their initial state after a mode has been applied
+func protectNamespaceMeta(initial map[string]string, metaObject map[string]string, protectedKeys []string) map[string]string {
+	for key := range metaObject {
+		if _, ok := initial[key]; !ok && knamespace.KeyMatches(protectedKeys, key) {
+			delete(metaObject, key)
+		}
+	}
+	for key, value := range initial {
+		if knamespace.KeyMatches(protectedKeys, key) {
+			if metaObject == nil {
+				metaObject = make(map[string]string)
+			}
+			metaObject[key] = value
+		}
+	}
+	return metaObject
+}
+
+// Copy an Annotations or Labels map
+func copyMeta(metaObject map[string]string) map[string]string {
+	if metaObject == nil {
+		return nil
+	}
+	c := make(map[string]string, len(metaObject))
+	for key, value := range metaObject {
+		c[key] = value
+	}
+	return c
+}
+
+// Determine which Knamespaces don't exist in the cluster and create them. When the config changed from previous
+// only the namespaces of the entries it added are created.
+func createMissingNamespaces(k8s *kube.K8sClient, namespacesConfig *knamespace.NamespacesConfig, previous *knamespace.NamespacesConfig) error {
 	nsList, err := k8s.ListClusterNameSpaces()
 	if err != nil {
 		return err
 	}
+	namespacesToCreate := missingNamespaces(nsList, namespacesConfig)
+	if previous != nil {
+		namespacesToCreate = addedNamespaces(namespacesToCreate, namespacesConfig, previous)
+	}
+	if len(namespacesToCreate) == 0 {
+		return nil
+	}
+	if k8s.DryRun {
+		log.Infof("Dry run: would create configured name spaces that do not exist in cluster: %s", namespacesToCreate)
+	} else {
+		log.Infof("Creating configured name spaces that do not exist in cluster: %s", namespacesToCreate)
+	}
+	var failed []string
+	for _, namespaceName := range namespacesToCreate {
+		namespaceConfig, err := namespacesConfig.GetConfig(namespaceName, nil)
+		if err == nil {
+			err = createNamespace(k8s, namespaceName, namespaceConfig)
+		}
+		if err != nil {
+			log.Errorf("Unable to create namespace %s: %s", namespaceName, err)
+			failed = append(failed, namespaceName)
+			continue
+		}
+		recordNamespaceWrite("create", k8s.DryRun)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to create namespaces %s", failed)
+	}
+	return nil
+}
+
+// Determine which Knamespaces are not in the list of cluster namespaces
+func missingNamespaces(nsList *corev1.NamespaceList, namespacesConfig *knamespace.NamespacesConfig) []string {
 	var namespacesToCreate []string
 	for _, configNamespace := range namespacesConfig.Namespaces {
+		// Only entries with a concrete name can be created
+		if configNamespace.Name == "" {
+			continue
+		}
 		createNamespace := true
 		for _, ns := range nsList.Items {
 			if ns.Name == configNamespace.Name {
@@ -129,6 +431,32 @@ func createMissingNamespaces(k8s *kube.K8sClient, namespacesConfig *knamespace.N
 			namespacesToCreate = append(namespacesToCreate, configNamespace.Name)
 		}
 	}
-	log.Infof("Creating configured name spaces that do not exist in cluster: %s", namespacesToCreate)
-	return k8s.CreateNamespaces(namespacesToCreate)
+	return namespacesToCreate
+}
+
+// The namespaces whose name entry is new since previous, so a namespace that was deleted on purpose isn't
+// created again by an unrelated config change. Entries that set recreate to false are left out as well.
+func addedNamespaces(namespaceNames []string, namespacesConfig *knamespace.NamespacesConfig, previous *knamespace.NamespacesConfig) []string {
+	var added []string
+	for _, namespaceName := range namespaceNames {
+		if i := previous.MatchingEntry(namespaceName, nil); i >= 0 && previous.Namespaces[i].Name == namespaceName {
+			continue
+		}
+		namespaceConfig, err := namespacesConfig.GetConfig(namespaceName, nil)
+		if err != nil || !namespaceConfig.GetRecreate() {
+			continue
+		}
+		added = append(added, namespaceName)
+	}
+	return added
+}
+
+// Determine whether the configuration that applies to a namespace differs between two NamespacesConfigs
+func configChanged(previous *knamespace.NamespacesConfig, current *knamespace.NamespacesConfig, namespace *corev1.Namespace) bool {
+	if previous == nil || current == nil {
+		return previous != current
+	}
+	before, _ := previous.GetConfig(namespace.Name, namespace.Labels)
+	after, _ := current.GetConfig(namespace.Name, namespace.Labels)
+	return !reflect.DeepEqual(before, after)
 }
@@ -0,0 +1,311 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/ejether/knamespacer/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func testNamespace(labels map[string]string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+}
+
+// A fake client that also serves the server-side applies it doesn't support itself
+func newFakeClient(objs ...client.Object) client.WithWatch {
+	return fake.NewClientBuilder().WithObjects(objs...).WithInterceptorFuncs(interceptor.Funcs{Patch: fakeApply}).Build()
+}
+
+// Serve a server-side apply of a namespace by creating it or by setting the applied annotations and labels on it
+func fakeApply(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Patch(ctx, obj, patch, opts...)
+	}
+	namespace := &corev1.Namespace{}
+	err := c.Get(ctx, client.ObjectKeyFromObject(obj), namespace)
+	if apierrors.IsNotFound(err) {
+		namespace.Name = obj.GetName()
+		namespace.Labels = obj.GetLabels()
+		namespace.Annotations = obj.GetAnnotations()
+		return c.Create(ctx, namespace)
+	}
+	if err != nil {
+		return err
+	}
+	namespace.Labels = mergeMeta(namespace.Labels, obj.GetLabels())
+	namespace.Annotations = mergeMeta(namespace.Annotations, obj.GetAnnotations())
+	return c.Update(ctx, namespace)
+}
+
+func mergeMeta(metaObject map[string]string, applied map[string]string) map[string]string {
+	if metaObject == nil {
+		metaObject = make(map[string]string)
+	}
+	for key, value := range applied {
+		metaObject[key] = value
+	}
+	return metaObject
+}
+
+func TestModifyNamespaceMetadata(t *testing.T) {
+	existing := map[string]string{"keep": "existing", "change": "existing"}
+	config := map[string]string{"change": "config", "add": "config"}
+
+	tests := []struct {
+		mode     string
+		expected map[string]string
+	}{
+		{knamespace.ModeSync, map[string]string{"change": "config", "add": "config"}},
+		{knamespace.ModeUpsert, map[string]string{"keep": "existing", "change": "config", "add": "config"}},
+		{knamespace.ModeInsert, map[string]string{"keep": "existing", "change": "existing", "add": "config"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			namespace := testNamespace(copyMeta(existing), copyMeta(existing))
+			ModifyNamespaceMetadata(namespace, &knamespace.NamespaceConfig{
+				Mode:        test.mode,
+				Labels:      config,
+				Annotations: config,
+			})
+			assert.Equal(t, test.expected, namespace.Labels)
+			assert.Equal(t, test.expected, namespace.Annotations)
+		})
+	}
+}
+
+func TestModifyNamespaceMetadataKeyModes(t *testing.T) {
+	namespace := testNamespace(
+		map[string]string{"owner": "self-service", "cost-center": "wrong", "other": "existing"},
+		map[string]string{"owner": "self-service", "other": "existing"},
+	)
+	ModifyNamespaceMetadata(namespace, &knamespace.NamespaceConfig{
+		Mode:            knamespace.ModeSync,
+		AnnotationsMode: knamespace.ModeInsert,
+		Labels:          map[string]string{"owner": "platform", "cost-center": "1234"},
+		Annotations:     map[string]string{"owner": "platform", "cost-center": "1234", "other": "config"},
+		KeyModes:        map[string]string{"owner": knamespace.ModeInsert, "cost-center": knamespace.ModeUpsert},
+	})
+
+	assert.Equal(t, map[string]string{"owner": "self-service", "cost-center": "1234"}, namespace.Labels)
+	assert.Equal(t, map[string]string{"owner": "self-service", "cost-center": "1234", "other": "existing"}, namespace.Annotations)
+}
+
+func TestModifyNamespaceMetadataRemoveKeys(t *testing.T) {
+	for _, mode := range knamespace.Modes {
+		t.Run(mode, func(t *testing.T) {
+			namespace := testNamespace(
+				map[string]string{"kubernetes.io/metadata.name": "test", "deprecated": "true", "team": "platform"},
+				map[string]string{"legacy.example.com/owner": "someone", "legacy.example.com/team": "platform", "note": "keep"},
+			)
+			ModifyNamespaceMetadata(namespace, &knamespace.NamespaceConfig{
+				Mode:              mode,
+				Labels:            map[string]string{"team": "platform"},
+				Annotations:       map[string]string{"note": "keep"},
+				RemoveLabels:      []string{"deprecated", "kubernetes.io/metadata.name"},
+				RemoveAnnotations: []string{"legacy.example.com/*"},
+				ProtectedKeys:     knamespace.DefaultProtectedKeys,
+			})
+
+			assert.Equal(t, map[string]string{"kubernetes.io/metadata.name": "test", "team": "platform"}, namespace.Labels)
+			assert.Equal(t, map[string]string{"note": "keep"}, namespace.Annotations)
+		})
+	}
+}
+
+func TestModifyNamespaceMetadataProtectedKeys(t *testing.T) {
+	config := &knamespace.NamespaceConfig{
+		Labels: map[string]string{
+			"pod-security.kubernetes.io/enforce": "privileged",
+			"pod-security.kubernetes.io/warn":    "privileged",
+			"team":                               "platform",
+		},
+		ProtectedKeys: knamespace.DefaultProtectedKeys,
+	}
+
+	for _, mode := range knamespace.Modes {
+		t.Run(mode, func(t *testing.T) {
+			namespace := testNamespace(map[string]string{
+				"kubernetes.io/metadata.name":        "test",
+				"pod-security.kubernetes.io/enforce": "restricted",
+			}, nil)
+			config.Mode = mode
+			ModifyNamespaceMetadata(namespace, config)
+
+			assert.Equal(t, map[string]string{
+				"kubernetes.io/metadata.name":        "test",
+				"pod-security.kubernetes.io/enforce": "restricted",
+				"team":                               "platform",
+			}, namespace.Labels)
+		})
+	}
+}
+
+func TestModifyNamespaceMetadataManaged(t *testing.T) {
+	namespace := testNamespace(map[string]string{
+		"kubernetes.io/metadata.name": "test",
+		"retired":                     "knamespacer",
+		"shared":                      "both",
+		"kept":                        "knamespacer",
+	}, nil)
+	namespace.ManagedFields = []metav1.ManagedFieldsEntry{
+		{
+			Manager:   kube.FieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{
+				Raw: []byte(`{"f:metadata":{"f:labels":{"f:retired":{},"f:shared":{},"f:kept":{}}}}`),
+			},
+		},
+		{
+			Manager:   "other-controller",
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{
+				Raw: []byte(`{"f:metadata":{"f:labels":{"f:shared":{}}}}`),
+			},
+		},
+	}
+
+	ModifyNamespaceMetadata(namespace, &knamespace.NamespaceConfig{
+		Mode:   knamespace.ModeManaged,
+		Labels: map[string]string{"kept": "knamespacer", "added": "knamespacer"},
+	})
+
+	assert.Equal(t, map[string]string{
+		"kubernetes.io/metadata.name": "test",
+		"shared":                      "both",
+		"kept":                        "knamespacer",
+		"added":                       "knamespacer",
+	}, namespace.Labels)
+}
+
+func TestConfigChanged(t *testing.T) {
+	previous := &knamespace.NamespacesConfig{
+		Namespaces: []knamespace.NamespaceConfig{
+			{Name: "same", Mode: knamespace.ModeUpsert},
+			{Name: "changed", Mode: knamespace.ModeUpsert},
+			{Name: "removed", Mode: knamespace.ModeUpsert},
+		},
+	}
+	current := &knamespace.NamespacesConfig{
+		Namespaces: []knamespace.NamespaceConfig{
+			{Name: "same", Mode: knamespace.ModeUpsert},
+			{Name: "changed", Mode: knamespace.ModeSync},
+			{Name: "added", Mode: knamespace.ModeUpsert},
+		},
+	}
+
+	for namespaceName, changed := range map[string]bool{
+		"same":         false,
+		"changed":      true,
+		"removed":      true,
+		"added":        true,
+		"unconfigured": false,
+	} {
+		namespace := testNamespace(nil, nil)
+		namespace.Name = namespaceName
+		assert.Equal(t, changed, configChanged(previous, current, namespace), namespaceName)
+	}
+}
+
+func TestRecreateEntry(t *testing.T) {
+	recreate := false
+	namespacesConfig := &knamespace.NamespacesConfig{
+		DefaultConfig:      knamespace.NamespaceConfig{Mode: knamespace.ModeUpsert},
+		ApplyDefaultsToAll: true,
+		Namespaces: []knamespace.NamespaceConfig{
+			{Name: "recreated"},
+			{Name: "opted-out", Recreate: &recreate},
+			{NameGlob: "app-*"},
+		},
+	}
+
+	for namespaceName, recreated := range map[string]bool{
+		"recreated":    true,
+		"opted-out":    false,
+		"app-one":      false,
+		"unconfigured": false,
+	} {
+		assert.Equal(t, recreated, recreateEntry(namespacesConfig, namespaceName) != nil, namespaceName)
+	}
+
+	// The default applies to entries that don't set recreate
+	namespacesConfig.DefaultConfig.Recreate = &recreate
+	assert.Nil(t, recreateEntry(namespacesConfig, "recreated"))
+}
+
+func TestEnforcedKeys(t *testing.T) {
+	namespaceConfig := &knamespace.NamespaceConfig{
+		Mode:            knamespace.ModeUpsert,
+		AnnotationsMode: knamespace.ModeInsert,
+		Labels:          map[string]string{"team": "a", "tier": "b"},
+		Annotations:     map[string]string{"owner": "a", "contact": "b"},
+		KeyModes:        map[string]string{"tier": knamespace.ModeInsert, "contact": knamespace.ModeUpsert},
+	}
+
+	enforced := enforcedKeys(namespaceConfig)
+	assert.Equal(t, map[string]bool{"team": true}, enforced.Labels)
+	assert.Equal(t, map[string]bool{"contact": true}, enforced.Annotations)
+}
+
+func TestOwnsEnforcedKeys(t *testing.T) {
+	// The creation webhook set team, which nobody owns
+	namespace := testNamespace(map[string]string{"team": "platform", "tier": "web"}, nil)
+	after := NamespaceMetadata{Labels: map[string]string{"team": "platform", "tier": "web"}}
+	enforced := kube.EnforcedKeys{Labels: map[string]bool{"team": true, "removed": true}}
+	assert.False(t, ownsEnforcedKeys(namespace, after, enforced))
+
+	namespace.ManagedFields = []metav1.ManagedFieldsEntry{{
+		Manager:   kube.FieldManager,
+		Operation: metav1.ManagedFieldsOperationApply,
+		FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:team":{}}}}`)},
+	}}
+	assert.True(t, ownsEnforcedKeys(namespace, after, enforced))
+}
+
+func TestMissingNamespaces(t *testing.T) {
+	nsList := &corev1.NamespaceList{
+		Items: []corev1.Namespace{*testNamespace(nil, nil)},
+	}
+	namespacesConfig := &knamespace.NamespacesConfig{
+		Namespaces: []knamespace.NamespaceConfig{
+			{Name: "test"},
+			{Name: "missing"},
+			{NamePattern: "^team-"},
+			{NameGlob: "app-*"},
+		},
+	}
+
+	assert.Equal(t, []string{"missing"}, missingNamespaces(nsList, namespacesConfig))
+}
@@ -0,0 +1,95 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	namespaceWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "knamespacer_namespace_writes_total",
+		Help: "Namespace creates, updates and takeovers of enforced keys made, or in dry run mode that would have been made",
+	}, []string{"operation", "dry_run"})
+
+	metadataChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "knamespacer_metadata_changes_total",
+		Help: "Annotation and label keys added, changed or removed, or in dry run mode that would have been",
+	}, []string{"namespace", "type", "change", "dry_run"})
+
+	outOfPolicyKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "knamespacer_namespace_out_of_policy_keys",
+		Help: "Annotation and label keys that did not match the config when the namespace was last reconciled",
+	}, []string{"namespace"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(namespaceWrites, metadataChanges, outOfPolicyKeys)
+}
+
+// Count a namespace create, update or take_ownership, an update that only takes over enforced keys
+func recordNamespaceWrite(operation string, dryRun bool) {
+	namespaceWrites.WithLabelValues(operation, strconv.FormatBool(dryRun)).Inc()
+}
+
+// Count the annotation and label keys that were changed, or in dry run mode would have been. Call once the
+// write succeeded.
+func recordMetadataChanges(namespaceName string, before NamespaceMetadata, after NamespaceMetadata, dryRun bool) {
+	dryRunLabel := strconv.FormatBool(dryRun)
+	for _, change := range mapChanges(before.Annotations, after.Annotations) {
+		metadataChanges.WithLabelValues(namespaceName, "annotation", change, dryRunLabel).Inc()
+	}
+	for _, change := range mapChanges(before.Labels, after.Labels) {
+		metadataChanges.WithLabelValues(namespaceName, "label", change, dryRunLabel).Inc()
+	}
+}
+
+// Report how many annotation and label keys of a namespace don't match its config
+func recordOutOfPolicyKeys(namespaceName string, before NamespaceMetadata, after NamespaceMetadata) {
+	changed := len(mapChanges(before.Annotations, after.Annotations)) + len(mapChanges(before.Labels, after.Labels))
+	outOfPolicyKeys.WithLabelValues(namespaceName).Set(float64(changed))
+}
+
+// Drop the series of a namespace that no longer exists
+func forgetNamespaceMetrics(namespaceName string) {
+	outOfPolicyKeys.DeleteLabelValues(namespaceName)
+	metadataChanges.DeletePartialMatch(prometheus.Labels{"namespace": namespaceName})
+}
+
+// How each key that differs between two Annotations or Labels maps changes: add, change or remove
+func mapChanges(before map[string]string, after map[string]string) map[string]string {
+	changes := make(map[string]string)
+	for key, value := range after {
+		previous, ok := before[key]
+		switch {
+		case !ok:
+			changes[key] = "add"
+		case previous != value:
+			changes[key] = "change"
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changes[key] = "remove"
+		}
+	}
+	return changes
+}
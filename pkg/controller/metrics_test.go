@@ -0,0 +1,72 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/ejether/knamespacer/pkg/kube"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapChanges(t *testing.T) {
+	assert.Equal(t, map[string]string{"added": "add", "changed": "change", "removed": "remove"}, mapChanges(
+		map[string]string{"same": "x", "changed": "x", "removed": "x"},
+		map[string]string{"same": "x", "changed": "y", "added": "y"},
+	))
+	assert.Empty(t, mapChanges(nil, map[string]string{}))
+}
+
+func TestForgetNamespaceMetrics(t *testing.T) {
+	before := NamespaceMetadata{Labels: map[string]string{"team": "old"}}
+	after := NamespaceMetadata{Labels: map[string]string{"team": "new"}}
+	recordOutOfPolicyKeys("metrics-test", before, after)
+	recordMetadataChanges("metrics-test", before, after, false)
+	assert.Equal(t, float64(1), testutil.ToFloat64(outOfPolicyKeys.WithLabelValues("metrics-test")))
+
+	forgetNamespaceMetrics("metrics-test")
+	// Nothing is left to delete
+	assert.False(t, outOfPolicyKeys.DeleteLabelValues("metrics-test"))
+	assert.False(t, metadataChanges.DeleteLabelValues("metrics-test", "label", "change", "false"))
+}
+
+func TestUpdateNamespaceWrites(t *testing.T) {
+	namespacesConfig := &knamespace.NamespacesConfig{
+		Namespaces: []knamespace.NamespaceConfig{
+			{Name: "test", Mode: knamespace.ModeUpsert, Labels: map[string]string{"team": "platform"}},
+		},
+	}
+	// The namespace matches its config but Knamespacer doesn't own team yet
+	namespace := testNamespace(map[string]string{"team": "platform"}, nil)
+	k8s := &kube.K8sClient{K8s: newFakeClient(namespace.DeepCopy()), DryRun: true}
+	writes := func(operation string, dryRun string) float64 {
+		return testutil.ToFloat64(namespaceWrites.WithLabelValues(operation, dryRun))
+	}
+	dryRunUpdates, updates, takeovers := writes("update", "true"), writes("update", "false"), writes("take_ownership", "false")
+
+	// A dry run can't take ownership, so nothing would be written
+	assert.Nil(t, updateNamespace(k8s, namespace, namespacesConfig))
+	assert.Equal(t, dryRunUpdates, writes("update", "true"))
+
+	k8s.DryRun = false
+	assert.Nil(t, updateNamespace(k8s, namespace, namespacesConfig))
+	assert.Equal(t, takeovers+1, writes("take_ownership", "false"))
+	assert.Equal(t, updates, writes("update", "false"))
+}
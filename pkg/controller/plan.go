@@ -0,0 +1,186 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/ejether/knamespacer/pkg/kube"
+
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The changes Knamespacer would make to the cluster for a NamespacesConfig
+type Plan struct {
+	// Configured namespaces that don't exist in the cluster
+	Create []string `json:"create"`
+	// Namespaces whose metadata would change, including the ones that would be created
+	Changes []NamespaceChange `json:"changes"`
+}
+
+// Whether Knamespacer would create or change any namespace
+func (p *Plan) HasChanges() bool {
+	return len(p.Create) > 0 || len(p.Changes) > 0
+}
+
+// The metadata change Knamespacer would make to a single namespace
+type NamespaceChange struct {
+	Name   string            `json:"name"`
+	Create bool              `json:"create"`
+	Before NamespaceMetadata `json:"before"`
+	After  NamespaceMetadata `json:"after"`
+	// Unified diff between Before and After
+	Diff string `json:"diff"`
+}
+
+// The Knamespacer managed parts of a namespace's metadata
+type NamespaceMetadata struct {
+	Annotations map[string]string `json:"annotations"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// Compute what Knamespacer would change in the cluster without changing anything
+func PlanNamespaces(k8s *kube.K8sClient, namespacesConfig *knamespace.NamespacesConfig) (*Plan, error) {
+	nsList, err := k8s.ListClusterNameSpaces()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Create:  missingNamespaces(nsList, namespacesConfig),
+		Changes: []NamespaceChange{},
+	}
+
+	namespaces := nsList.Items
+	for _, namespaceName := range plan.Create {
+		namespaces = append(namespaces, corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespaceName,
+			},
+		})
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+
+	creating := make(map[string]bool, len(plan.Create))
+	for _, namespaceName := range plan.Create {
+		creating[namespaceName] = true
+	}
+
+	for i := range namespaces {
+		change, err := planNamespace(&namespaces[i], namespacesConfig)
+		if err != nil {
+			return nil, err
+		}
+		if change == nil {
+			continue
+		}
+		change.Create = creating[change.Name]
+		plan.Changes = append(plan.Changes, *change)
+	}
+
+	return plan, nil
+}
+
+// Compute the metadata change for one namespace. Returns nil if nothing would change
+func planNamespace(namespace *corev1.Namespace, namespacesConfig *knamespace.NamespacesConfig) (*NamespaceChange, error) {
+	namespaceConfig, err := namespacesConfig.GetConfig(namespace.Name, namespace.Labels)
+	if err != nil {
+		// Not configured
+		return nil, nil
+	}
+	if err := renderNamespaceConfig(namespaceConfig, namespace); err != nil {
+		return nil, err
+	}
+
+	modified := namespace.DeepCopy()
+	ModifyNamespaceMetadata(modified, namespaceConfig)
+
+	before := namespaceMetadata(namespace)
+	after := namespaceMetadata(modified)
+	if reflect.DeepEqual(before, after) {
+		return nil, nil
+	}
+
+	diff, err := metadataDiff(namespace.Name, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NamespaceChange{
+		Name:   namespace.Name,
+		Before: before,
+		After:  after,
+		Diff:   diff,
+	}, nil
+}
+
+// Unified diff of a namespace's metadata
+func metadataDiff(namespaceName string, before NamespaceMetadata, after NamespaceMetadata) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        metadataLines(before),
+		B:        metadataLines(after),
+		FromFile: fmt.Sprintf("a/namespaces/%s", namespaceName),
+		ToFile:   fmt.Sprintf("b/namespaces/%s", namespaceName),
+		Context:  3,
+	})
+}
+
+// Copy the annotations and labels of a namespace, treating missing maps as empty
+func namespaceMetadata(namespace *corev1.Namespace) NamespaceMetadata {
+	metadata := NamespaceMetadata{
+		Annotations: map[string]string{},
+		Labels:      map[string]string{},
+	}
+	for key, value := range namespace.Annotations {
+		metadata.Annotations[key] = value
+	}
+	for key, value := range namespace.Labels {
+		metadata.Labels[key] = value
+	}
+	return metadata
+}
+
+// Render metadata as sorted YAML-like lines for diffing
+func metadataLines(metadata NamespaceMetadata) []string {
+	var lines []string
+	for _, section := range []struct {
+		name   string
+		values map[string]string
+	}{
+		{"annotations", metadata.Annotations},
+		{"labels", metadata.Labels},
+	} {
+		lines = append(lines, section.name+":\n")
+		keys := make([]string, 0, len(section.values))
+		for key := range section.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			lines = append(lines, fmt.Sprintf("  %s: %q\n", key, section.values[key]))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,71 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/ejether/knamespacer/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPlanNamespace(t *testing.T) {
+	namespacesConfig := &knamespace.NamespacesConfig{
+		Namespaces: []knamespace.NamespaceConfig{
+			{
+				Name:   "test",
+				Mode:   knamespace.ModeUpsert,
+				Labels: map[string]string{"team": "platform"},
+			},
+		},
+	}
+
+	change, err := planNamespace(testNamespace(map[string]string{"team": "apps"}, nil), namespacesConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team": "platform"}, change.After.Labels)
+	assert.Equal(t, `--- a/namespaces/test
++++ b/namespaces/test
+@@ -1,3 +1,3 @@
+ annotations:
+ labels:
+-  team: "apps"
++  team: "platform"
+`, change.Diff)
+
+	change, err = planNamespace(testNamespace(map[string]string{"team": "platform"}, nil), namespacesConfig)
+	assert.Nil(t, err)
+	assert.Nil(t, change)
+}
+
+func TestPlanNamespacesCreateOnly(t *testing.T) {
+	namespacesConfig := &knamespace.NamespacesConfig{
+		Namespaces: []knamespace.NamespaceConfig{{Name: "new", Mode: knamespace.ModeUpsert}},
+	}
+	k8s := &kube.K8sClient{K8s: fake.NewClientBuilder().Build()}
+
+	// Creating a namespace without any configured metadata is a change too
+	plan, err := PlanNamespaces(k8s, namespacesConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"new"}, plan.Create)
+	assert.Empty(t, plan.Changes)
+	assert.True(t, plan.HasChanges())
+
+	assert.False(t, (&Plan{Changes: []NamespaceChange{}}).HasChanges())
+}
@@ -0,0 +1,207 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/ejether/knamespacer/pkg/apis/v1alpha1"
+	"github.com/ejether/knamespacer/pkg/knamespace"
+
+	log "github.com/sirupsen/logrus"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Feeds NamespacePolicies to a KnamespacerController as namespace entries alongside the config files and
+// reports in each policy's status whether it was applied and which namespaces it matches
+type NamespacePolicyController struct {
+	client.Client
+	Controller *KnamespacerController
+}
+
+// Policies are validated against each other, so every change re-evaluates all of them under this one request
+var allPolicies = reconcile.Request{NamespacedName: types.NamespacedName{Name: "namespacepolicies"}}
+
+func (r *NamespacePolicyController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	policyList := &v1alpha1.NamespacePolicyList{}
+	if err := r.List(ctx, policyList); err != nil {
+		return ctrl.Result{}, err
+	}
+	policies := policyList.Items
+	// The oldest policy wins when two configure the same namespaces
+	sort.Slice(policies, func(i, j int) bool {
+		if !policies[i].CreationTimestamp.Equal(&policies[j].CreationTimestamp) {
+			return policies[i].CreationTimestamp.Before(&policies[j].CreationTimestamp)
+		}
+		return policies[i].Name < policies[j].Name
+	})
+	log.Infof("Reconciling %d NamespacePolicies", len(policies))
+
+	policyConfigs := make([]*knamespace.NamespacesConfig, 0, len(policies))
+	for i := range policies {
+		policyConfigs = append(policyConfigs, knamespace.NewPolicyNamespacesConfig(policySource(&policies[i]), policies[i].NamespaceConfig()))
+	}
+	policyErrs, err := r.Controller.SetPolicies(ctx, policyConfigs)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("encounter %w while applying NamespacePolicies", err)
+	}
+
+	nsList := &corev1.NamespaceList{}
+	if err := r.List(ctx, nsList); err != nil {
+		return ctrl.Result{}, err
+	}
+	matched := matchedNamespaces(r.Controller.GetNamespaceConfig(), nsList)
+
+	for i := range policies {
+		policy := &policies[i]
+		if policyErrs[i] != nil {
+			log.Errorf("Rejected NamespacePolicy %s: %s", policy.Name, policyErrs[i])
+		}
+		if !r.Controller.leading() {
+			continue
+		}
+		if err := r.updateStatus(ctx, policy, matched[policySource(policy)], policyErrs[i]); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// Write a policy's status if it changed
+func (r *NamespacePolicyController) updateStatus(ctx context.Context, policy *v1alpha1.NamespacePolicy, matched []string, policyErr error) error {
+	status := policy.Status.DeepCopy()
+	status.ObservedGeneration = policy.Generation
+	status.MatchedNamespaces = matched
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             v1alpha1.ReasonApplied,
+		Message:            fmt.Sprintf("Applied to %d namespace(s)", len(matched)),
+		ObservedGeneration: policy.Generation,
+	}
+	if policyErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = v1alpha1.ReasonInvalid
+		condition.Message = policyErr.Error()
+		status.MatchedNamespaces = nil
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if equality.Semantic.DeepEqual(status, &policy.Status) {
+		return nil
+	}
+	policy.Status = *status
+	return r.Status().Update(ctx, policy)
+}
+
+// How a policy is named in validation errors and as the source of its entry
+func policySource(policy *v1alpha1.NamespacePolicy) string {
+	return "NamespacePolicy/" + policy.Name
+}
+
+// The names of the namespaces the entries of each source, such as a NamespacePolicy, apply to
+func matchedNamespaces(namespacesConfig *knamespace.NamespacesConfig, nsList *corev1.NamespaceList) map[string][]string {
+	matched := make(map[string][]string)
+	for _, namespace := range nsList.Items {
+		i := namespacesConfig.MatchingEntry(namespace.Name, namespace.Labels)
+		if i < 0 {
+			continue
+		}
+		source := namespacesConfig.EntrySource(i)
+		matched[source] = append(matched[source], namespace.Name)
+	}
+	for _, names := range matched {
+		sort.Strings(names)
+	}
+	return matched
+}
+
+// Matches the namespaces entry a policy became in a field path
+var policyEntryPath = regexp.MustCompile(`^namespaces\[\d+\]`)
+
+// Report the validation errors for the policy merged in as namespaces[index] against its spec
+func specErrors(err error, index int) error {
+	var validationErrs knamespace.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return err
+	}
+	entry := fmt.Sprintf("namespaces[%d]", index)
+
+	var errs knamespace.ValidationErrors
+	for _, validationErr := range validationErrs {
+		if validationErr.Field == entry || policyEntryPath.FindString(validationErr.Field) == entry {
+			validationErr.Field = "spec" + validationErr.Field[len(entry):]
+			validationErr.File = ""
+			validationErr.Line = 0
+		}
+		errs = append(errs, validationErr)
+	}
+	return errs
+}
+
+// SetupWithManager sets up the controller with the Manager. Runs on every replica so the webhooks they serve
+// include the policies, but only the leader writes policy statuses.
+func (r *NamespacePolicyController) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Controller.policyEvents == nil {
+		r.Controller.policyEvents = make(chan event.GenericEvent, 1)
+	}
+
+	// Bring the statuses up to date when this replica becomes the leader
+	if err := mgr.Add(manager.RunnableFunc(func(context.Context) error {
+		r.Controller.notifyPolicies()
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	needLeaderElection := false
+
+	enqueueAll := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{allPolicies}
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("namespacepolicy").
+		WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
+		// Status updates don't change the generation and are ignored
+		Watches(&v1alpha1.NamespacePolicy{}, enqueueAll, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// Namespaces coming and going and their labels changing change which policies match them
+		Watches(&corev1.Namespace{}, enqueueAll, builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		WatchesRawSource(&source.Channel{Source: r.Controller.policyEvents}, enqueueAll).
+		Complete(r)
+}
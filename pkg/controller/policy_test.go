@@ -0,0 +1,116 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/apis/v1alpha1"
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func testPolicy(name string, spec v1alpha1.NamespacePolicySpec) *knamespace.NamespacesConfig {
+	policy := &v1alpha1.NamespacePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       spec,
+	}
+	return knamespace.NewPolicyNamespacesConfig(policySource(policy), policy.NamespaceConfig())
+}
+
+func TestBuildConfig(t *testing.T) {
+	fileConfig := knamespace.NewNamespacesConfig("namespaces.yaml", knamespace.NamespaceConfig{Name: "from-file", Mode: knamespace.ModeUpsert})
+	r := &KnamespacerController{NamespaceConfig: fileConfig}
+	r.policies = []*knamespace.NamespacesConfig{
+		testPolicy("team-a", v1alpha1.NamespacePolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			Mode:              knamespace.ModeUpsert,
+			Labels:            map[string]string{"owner": "team-a"},
+		}),
+		testPolicy("duplicate", v1alpha1.NamespacePolicySpec{Name: "from-file", Mode: knamespace.ModeSync}),
+		testPolicy("invalid", v1alpha1.NamespacePolicySpec{Name: "other", Mode: "merge"}),
+		testPolicy("team-b", v1alpha1.NamespacePolicySpec{NameGlob: "team-b-*", Mode: knamespace.ModeInsert}),
+	}
+
+	namespacesConfig, policyErrs := r.buildConfig()
+
+	assert.Len(t, namespacesConfig.Namespaces, 3)
+	assert.Nil(t, policyErrs[0])
+	assert.Equal(t, `spec.name: Invalid value: "from-file": duplicate of namespaces[0] in namespaces.yaml`, policyErrs[1].Error())
+	assert.Equal(t, `spec.mode: Unsupported value: "merge": supported values: "insert", "managed", "sync", "upsert"`, policyErrs[2].Error())
+	assert.Nil(t, policyErrs[3])
+
+	nsList := &corev1.NamespaceList{Items: []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "from-file"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b-prod"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a-prod", Labels: map[string]string{"team": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a-dev", Labels: map[string]string{"team": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+	}}
+	assert.Equal(t, map[string][]string{
+		"namespaces.yaml":        {"from-file"},
+		"NamespacePolicy/team-a": {"team-a-dev", "team-a-prod"},
+		"NamespacePolicy/team-b": {"team-b-prod"},
+	}, matchedNamespaces(namespacesConfig, nsList))
+}
+
+func TestSetPoliciesUnchanged(t *testing.T) {
+	k8s := newFakeClient()
+	ctx := context.Background()
+	fileConfig := knamespace.NewNamespacesConfig("namespaces.yaml", knamespace.NamespaceConfig{Name: "from-file", Mode: knamespace.ModeUpsert})
+	r := &KnamespacerController{Client: k8s, NamespaceConfig: fileConfig}
+
+	// Policies that don't change the config don't create missing namespaces
+	policyErrs, err := r.SetPolicies(ctx, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, policyErrs)
+	assert.NotNil(t, k8s.Get(ctx, client.ObjectKey{Name: "from-file"}, &corev1.Namespace{}))
+
+	_, err = r.SetPolicies(ctx, []*knamespace.NamespacesConfig{
+		testPolicy("team-a", v1alpha1.NamespacePolicySpec{Name: "team-a", Mode: knamespace.ModeUpsert}),
+	})
+	assert.Nil(t, err)
+	assert.Len(t, r.GetNamespaceConfig().Namespaces, 2)
+	assert.Nil(t, k8s.Get(ctx, client.ObjectKey{Name: "team-a"}, &corev1.Namespace{}))
+}
+
+func TestSetPoliciesKeepsDeletedNamespaces(t *testing.T) {
+	recreate := false
+	fileConfig := knamespace.NewNamespacesConfig("namespaces.yaml", knamespace.NamespaceConfig{Name: "scratch", Mode: knamespace.ModeUpsert, Recreate: &recreate})
+	k8s := newFakeClient()
+	ctx := context.Background()
+	r := &KnamespacerController{Client: k8s, NamespaceConfig: fileConfig}
+
+	// scratch was deleted on purpose, an unrelated policy doesn't bring it back
+	_, err := r.SetPolicies(ctx, []*knamespace.NamespacesConfig{
+		testPolicy("team-a", v1alpha1.NamespacePolicySpec{Name: "team-a", Mode: knamespace.ModeUpsert}),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, k8s.Get(ctx, client.ObjectKey{Name: "team-a"}, &corev1.Namespace{}))
+	assert.NotNil(t, k8s.Get(ctx, client.ObjectKey{Name: "scratch"}, &corev1.Namespace{}))
+
+	_, err = r.SetPolicies(ctx, []*knamespace.NamespacesConfig{
+		testPolicy("team-a", v1alpha1.NamespacePolicySpec{Name: "team-a", Mode: knamespace.ModeSync}),
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, k8s.Get(ctx, client.ObjectKey{Name: "scratch"}, &corev1.Namespace{}))
+}
@@ -20,7 +20,11 @@ package controller
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sync"
+	"time"
 
+	"github.com/ejether/knamespacer/pkg/apis/v1alpha1"
 	"github.com/ejether/knamespacer/pkg/knamespace"
 	"github.com/ejether/knamespacer/pkg/kube"
 
@@ -29,14 +33,50 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Delay before retrying to create the configured namespaces at start up, doubled after each failure up to
+// maxStartUpRetryDelay
+const (
+	startUpRetryDelay    = time.Second
+	maxStartUpRetryDelay = 5 * time.Minute
 )
 
 type KnamespacerController struct {
 	client.Client
 	Scheme          *runtime.Scheme
 	NamespaceConfig *knamespace.NamespacesConfig
-	StartUp         bool
+	// Create the configured namespaces that don't exist whenever this replica starts leading
+	StartUp bool
+	// Reconcile as usual but don't change anything in the cluster
+	DryRun bool
+	// Take ownership of annotations and labels other field managers own instead of failing with a conflict, even of
+	// keys the config doesn't enforce
+	ForceConflicts bool
+
+	// Guards NamespaceConfig, which is swapped out when the config is reloaded
+	configLock sync.RWMutex
+	// Namespaces queued for reconciliation outside of namespace watch events
+	reconcileEvents chan event.GenericEvent
+
+	// NamespaceConfig is built from the config files, or config ConfigMap, and the NamespacePolicies. Until
+	// either changes NamespaceConfig is taken to be the config files'.
+	sourcesLock sync.Mutex
+	fileConfig  *knamespace.NamespacesConfig
+	policies    []*knamespace.NamespacesConfig
+	// Told when the config files change since that can change which NamespacePolicies are valid
+	policyEvents chan event.GenericEvent
+
+	// Closed once this replica is the leader. Every replica keeps its config current for the webhooks but
+	// only the leader writes to the cluster.
+	elected <-chan struct{}
 }
 
 func (r *KnamespacerController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -44,28 +84,208 @@ func (r *KnamespacerController) Reconcile(ctx context.Context, req ctrl.Request)
 	namespaceName := req.Name
 	log.Infof("Reconiling: %v", namespaceName)
 
-	k8s := &kube.K8sClient{
-		K8s: r.Client,
+	k8s := r.k8sClient()
+
+	namespacesConfig := r.GetNamespaceConfig()
+
+	result, err := processNamespace(k8s, namespaceName, namespacesConfig)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("encounter %w while processing %s. Skipping", err, namespaceName)
 	}
+	return result, nil
+}
 
-	if r.StartUp {
-		err := createMissingNamespaces(k8s, r.NamespaceConfig)
+// Build the client namespaces are read and written with
+func (r *KnamespacerController) k8sClient() *kube.K8sClient {
+	return &kube.K8sClient{
+		K8s:            r.Client,
+		DryRun:         r.DryRun,
+		ForceOwnership: r.ForceConflicts,
+	}
+}
+
+// Return the NamespacesConfig currently in use
+func (r *KnamespacerController) GetNamespaceConfig() *knamespace.NamespacesConfig {
+	r.configLock.RLock()
+	defer r.configLock.RUnlock()
+	return r.NamespaceConfig
+}
+
+// Swap in a new NamespacesConfig, create any newly configured namespaces and queue every cluster
+// namespace whose configuration changed for reconciliation
+func (r *KnamespacerController) SetNamespaceConfig(ctx context.Context, namespacesConfig *knamespace.NamespacesConfig) error {
+	r.configLock.Lock()
+	previous := r.NamespaceConfig
+	r.NamespaceConfig = namespacesConfig
+	r.configLock.Unlock()
+
+	// A replica that takes over leadership creates missing namespaces and reconciles every namespace then
+	if !r.leading() {
+		return nil
+	}
+
+	k8s := r.k8sClient()
+
+	if err := createMissingNamespaces(k8s, namespacesConfig, previous); err != nil {
+		return err
+	}
+
+	nsList, err := k8s.ListClusterNameSpaces()
+	if err != nil {
+		return err
+	}
+
+	for i := range nsList.Items {
+		namespace := &nsList.Items[i]
+		if !configChanged(previous, namespacesConfig, namespace) {
+			continue
+		}
+		log.Infof("Config changed for namespace %s. Queueing for reconciliation", namespace.Name)
+		if r.reconcileEvents == nil {
+			continue
+		}
+		select {
+		case r.reconcileEvents <- event.GenericEvent{Object: namespace}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Replace the config loaded from the config files or ConfigMap and apply it together with the NamespacePolicies
+func (r *KnamespacerController) SetFileConfig(ctx context.Context, namespacesConfig *knamespace.NamespacesConfig) error {
+	r.sourcesLock.Lock()
+	defer r.sourcesLock.Unlock()
+
+	r.fileConfig = namespacesConfig
+	merged, _ := r.buildConfig()
+	if err := r.SetNamespaceConfig(ctx, merged); err != nil {
+		return err
+	}
+	r.notifyPolicies()
+	return nil
+}
+
+// Queue the NamespacePolicies for re-evaluation
+func (r *KnamespacerController) notifyPolicies() {
+	if r.policyEvents == nil {
+		return
+	}
+	select {
+	case r.policyEvents <- event.GenericEvent{Object: &v1alpha1.NamespacePolicy{}}:
+	default:
+		// An update is already pending
+	}
+}
+
+// Whether this replica is the leader, or leader election is off
+func (r *KnamespacerController) leading() bool {
+	if r.elected == nil {
+		return true
+	}
+	select {
+	case <-r.elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// Replace the NamespacePolicies, each as a NamespacesConfig with a single entry, and apply them together
+// with the config files. Returns why each policy that can't be applied was rejected, by index.
+// Policies are re-evaluated on every namespace label change, so the config is only set when they change it.
+func (r *KnamespacerController) SetPolicies(ctx context.Context, policies []*knamespace.NamespacesConfig) ([]error, error) {
+	r.sourcesLock.Lock()
+	defer r.sourcesLock.Unlock()
+
+	r.policies = policies
+	merged, policyErrs := r.buildConfig()
+	if reflect.DeepEqual(merged, r.GetNamespaceConfig()) {
+		return policyErrs, nil
+	}
+	return policyErrs, r.SetNamespaceConfig(ctx, merged)
+}
+
+// The config files' config, as last loaded
+func (r *KnamespacerController) getFileConfig() *knamespace.NamespacesConfig {
+	r.sourcesLock.Lock()
+	defer r.sourcesLock.Unlock()
+	if r.fileConfig == nil {
+		return r.GetNamespaceConfig()
+	}
+	return r.fileConfig
+}
+
+// Merge the NamespacePolicies into the config files' config one at a time. A policy that would make the
+// config invalid, for instance by configuring a namespace that is already configured, is left out.
+func (r *KnamespacerController) buildConfig() (*knamespace.NamespacesConfig, []error) {
+	if r.fileConfig == nil {
+		r.fileConfig = r.GetNamespaceConfig()
+	}
+
+	namespacesConfig := r.fileConfig
+	policyErrs := make([]error, len(r.policies))
+	for i, policy := range r.policies {
+		candidate, err := knamespace.Merge(namespacesConfig, policy)
+		if err == nil {
+			err = candidate.Validate()
+		}
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("encounter %w while creating %s. Skipping", err, namespaceName)
+			policyErrs[i] = specErrors(err, len(namespacesConfig.Namespaces))
+			continue
 		}
-		r.StartUp = false
+		namespacesConfig = candidate
 	}
+	return namespacesConfig, policyErrs
+}
 
-	err := processNamespace(k8s, namespaceName, r.NamespaceConfig)
-	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("encounter %w while processing %s. Skipping", err, namespaceName)
+// Create the configured namespaces that don't exist, retrying with backoff until they all are or ctx is done.
+// The first attempts can fail, for instance when a webhook rejects the creates until this replica is ready.
+func (r *KnamespacerController) startUp(ctx context.Context) error {
+	delay := startUpRetryDelay
+	for {
+		err := createMissingNamespaces(r.k8sClient(), r.GetNamespaceConfig(), nil)
+		if err == nil {
+			return nil
+		}
+		log.Errorf("Failed to create configured namespaces at start up, retrying in %s: %s", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxStartUpRetryDelay {
+			delay = maxStartUpRetryDelay
+		}
 	}
-	return ctrl.Result{}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *KnamespacerController) SetupWithManager(mgr ctrl.Manager) error {
+	if r.reconcileEvents == nil {
+		r.reconcileEvents = make(chan event.GenericEvent)
+	}
+	r.elected = mgr.Elected()
+
+	// A Runnable rather than part of Reconcile so it runs once each time leadership is gained, after the
+	// cache has synced, and never on replicas that aren't leading
+	if r.StartUp {
+		if err := mgr.Add(manager.RunnableFunc(r.startUp)); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Namespace{}).
+		// Label changes can move a namespace in or out of namespaceSelector entries, annotation and label
+		// changes can drift from the config. Nothing else about a namespace matters. Creates and deletes
+		// always pass, deletes so configured namespaces are recreated.
+		For(&corev1.Namespace{}, builder.WithPredicates(predicate.Or(
+			predicate.LabelChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		WatchesRawSource(&source.Channel{Source: r.reconcileEvents}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
@@ -0,0 +1,70 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestStartUpRetries(t *testing.T) {
+	// The first create is rejected, as a webhook does until the replica serving it is ready
+	attempts := 0
+	k8s := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			attempts++
+			if attempts == 1 {
+				return errors.New("webhook not ready")
+			}
+			return fakeApply(ctx, c, obj, patch, opts...)
+		},
+	}).Build()
+	ctx := context.Background()
+	namespacesConfig := knamespace.NewNamespacesConfig("namespaces.yaml", knamespace.NamespaceConfig{
+		Name:   "team-a",
+		Mode:   knamespace.ModeUpsert,
+		Labels: map[string]string{"team": "a"},
+	})
+	r := &KnamespacerController{Client: k8s, NamespaceConfig: namespacesConfig}
+
+	assert.Nil(t, r.startUp(ctx))
+	assert.Equal(t, 2, attempts)
+	// Namespaces are created with their configured metadata
+	namespace := &corev1.Namespace{}
+	assert.Nil(t, k8s.Get(ctx, client.ObjectKey{Name: "team-a"}, namespace))
+	assert.Equal(t, "a", namespace.Labels["team"])
+
+	// Cancelling stops the retries
+	k8s = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(context.Context, client.WithWatch, client.Object, client.Patch, ...client.PatchOption) error {
+			return errors.New("webhook not ready")
+		},
+	}).Build()
+	r = &KnamespacerController{Client: k8s, NamespaceConfig: namespacesConfig}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Nil(t, r.startUp(cancelled))
+}
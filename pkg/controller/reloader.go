@@ -0,0 +1,128 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// How long to wait for a burst of file events to settle before reloading
+const reloadDelay = time.Second
+
+// Watches the Knamespacer config files and directories and hands every valid new version of them to the
+// controller. Invalid versions are logged and the last good config is kept.
+type ConfigReloader struct {
+	ConfigFiles []string
+	Controller  *KnamespacerController
+}
+
+// Every replica reloads the config so the webhooks it serves use the current one. Implements
+// manager.LeaderElectionRunnable
+func (c *ConfigReloader) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watching the config files. Implements manager.Runnable
+func (c *ConfigReloader) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for _, configDir := range watchDirs(c.ConfigFiles) {
+		if err := watcher.Add(configDir); err != nil {
+			return err
+		}
+		log.Infof("Watching %s for config changes", configDir)
+	}
+
+	// The files may have changed since they were first loaded
+	c.reload(ctx)
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			log.Debugf("Config watch event: %s", event)
+			timer.Reset(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Errorf("Error watching config files %s: %s", c.ConfigFiles, err)
+		case <-timer.C:
+			c.reload(ctx)
+		}
+	}
+}
+
+// The directories to watch for changes to config files and directories. Files are watched through their
+// directory since mounted ConfigMaps are updated by swapping a symlink, which replaces the file instead of
+// writing to it. Directories are watched themselves so added and removed files are picked up.
+func watchDirs(configFiles []string) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, configFile := range configFiles {
+		dir := configFile
+		if info, err := os.Stat(configFile); err != nil || !info.IsDir() {
+			dir = filepath.Dir(configFile)
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Re-read the config files and swap them in if they are valid and have changed
+func (c *ConfigReloader) reload(ctx context.Context) {
+	namespacesConfig, err := knamespace.GetNamespacesConfig(c.ConfigFiles...)
+	if err != nil {
+		log.Errorf("Rejected config %s, keeping the last good config: %s", c.ConfigFiles, err)
+		return
+	}
+
+	if reflect.DeepEqual(namespacesConfig, c.Controller.getFileConfig()) {
+		log.Debugf("Config %s unchanged", c.ConfigFiles)
+		return
+	}
+
+	log.Infof("Reloading config %s", c.ConfigFiles)
+	if err := c.Controller.SetFileConfig(ctx, namespacesConfig); err != nil {
+		log.Errorf("Error applying reloaded config %s: %s", c.ConfigFiles, err)
+	}
+}
@@ -18,48 +18,415 @@
 package knamespace
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
+// Supported metadata modes
+const (
+	ModeSync   = "sync"
+	ModeUpsert = "upsert"
+	ModeInsert = "insert"
+	// Like upsert, but keys Knamespacer applied earlier that are no longer configured are removed
+	ModeManaged = "managed"
+)
+
+// Modes lists every mode accepted in a NamespaceConfig
+var Modes = []string{ModeInsert, ModeManaged, ModeSync, ModeUpsert}
+
+// KeyModes lists every mode accepted for a single key in NamespaceConfig.KeyModes
+var KeyModes = []string{ModeInsert, ModeUpsert}
+
+// Setting this annotation to "true" on a namespace allows deleting it even if its entry sets preventDeletion
+const AllowDeletionAnnotation = "knamespacer.io/allow-deletion"
+
+// Annotation and label keys that are protected unless DisableDefaultProtectedKeys is set. They are
+// maintained by Kubernetes itself, by admission controllers or by people working around Knamespacer.
+var DefaultProtectedKeys = []string{
+	"kubernetes.io/metadata.name",
+	"pod-security.kubernetes.io/*",
+	AllowDeletionAnnotation,
+}
+
 type NamespaceConfig struct {
-	Name        string            `yaml:"name"`
+	// Exactly one of Name, NamePattern, NameGlob or NamespaceSelector selects the namespaces an entry applies to
+	Name string `yaml:"name"`
+	// Regular expression matched against namespace names
+	NamePattern string `yaml:"namePattern"`
+	// Shell glob matched against namespace names
+	NameGlob string `yaml:"nameGlob"`
+	// Label selector matched against the namespace's existing labels
+	NamespaceSelector *LabelSelector `yaml:"namespaceSelector"`
+
+	// Names of profiles merged into this entry, in order
+	Inherits []string `yaml:"inherits"`
+
 	Mode        string            `yaml:"mode"`
 	Annotations map[string]string `yaml:"annotations"`
 	Labels      map[string]string `yaml:"labels"`
+
+	// Override Mode for the annotations or the labels only
+	AnnotationsMode string `yaml:"annotationsMode"`
+	LabelsMode      string `yaml:"labelsMode"`
+	// Override the mode of individual annotation and label keys. Only insert and upsert apply to a single key
+	KeyModes map[string]string `yaml:"keyModes"`
+
+	// Annotation and label keys or globs, such as example.com/*, removed from the namespace in every mode
+	RemoveAnnotations []string `yaml:"removeAnnotations"`
+	RemoveLabels      []string `yaml:"removeLabels"`
+
+	// Recreate the namespace when it is deleted. Defaults to true and only applies to entries with a name.
+	// When false the namespace is still created when Knamespacer starts, but not when its config changes.
+	Recreate *bool `yaml:"recreate"`
+	// Deny deleting the namespace, see NamespacesConfig.DeletionProtection. Defaults to false
+	PreventDeletion *bool `yaml:"preventDeletion"`
+
+	// Annotation and label keys that must never be added, changed or removed. Not configurable per entry,
+	// GetConfig fills it in from NamespacesConfig.
+	ProtectedKeys []string `yaml:"-"`
+
+	// The entry comes from a NamespacePolicy, so it only applies to namespaces no config file entry matches
+	policy bool
+}
+
+// Users and groups allowed to delete namespaces whose entry sets preventDeletion. Anyone else has to set
+// AllowDeletionAnnotation on the namespace first.
+type DeletionProtection struct {
+	AllowedUsers  []string `yaml:"allowedUsers"`
+	AllowedGroups []string `yaml:"allowedGroups"`
+}
+
+// Whether a user, or any of their groups, may delete protected namespaces
+func (d DeletionProtection) Allows(username string, groups []string) bool {
+	for _, allowed := range d.AllowedUsers {
+		if allowed == username {
+			return true
+		}
+	}
+	for _, allowed := range d.AllowedGroups {
+		for _, group := range groups {
+			if allowed == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A Kubernetes label selector
+type LabelSelector struct {
+	MatchLabels      map[string]string          `yaml:"matchLabels"`
+	MatchExpressions []LabelSelectorRequirement `yaml:"matchExpressions"`
+}
+
+// A Kubernetes label selector requirement. Operator is one of In, NotIn, Exists or DoesNotExist
+type LabelSelectorRequirement struct {
+	Key      string   `yaml:"key"`
+	Operator string   `yaml:"operator"`
+	Values   []string `yaml:"values"`
 }
 
 type NamespacesConfig struct {
 	DefaultConfig NamespaceConfig   `yaml:"defaultNamespaceSettings"`
 	Namespaces    []NamespaceConfig `yaml:"namespaces"`
+	// Named settings that entries share by listing them in inherits
+	Profiles map[string]NamespaceConfig `yaml:"profiles"`
+	// Layer the default annotations, labels and key modes under an entry's own instead of using them only
+	// when the entry sets none
+	MergeDefaults bool `yaml:"mergeDefaults"`
+
+	// Apply DefaultConfig to every namespace that no entry matches, except those in ExcludeFromDefaults
+	ApplyDefaultsToAll bool `yaml:"applyDefaultsToAll"`
+	// Namespace names or globs that ApplyDefaultsToAll skips
+	ExcludeFromDefaults []string `yaml:"excludeFromDefaults"`
+
+	// Annotation and label keys or globs that are never added, changed or removed, in addition to
+	// DefaultProtectedKeys unless DisableDefaultProtectedKeys is set
+	ProtectedKeys               []string `yaml:"protectedKeys"`
+	DisableDefaultProtectedKeys bool     `yaml:"disableDefaultProtectedKeys"`
+
+	// Who may delete namespaces whose entry sets preventDeletion
+	DeletionProtection DeletionProtection `yaml:"deletionProtection"`
+
+	// Where the config was loaded from and where each field in it was defined. Used to report validation errors.
+	source    string
+	positions map[string]position
 }
 
-// Gets the config for a specific Knamespace
-func (n NamespacesConfig) GetConfig(namespaceName string) (*NamespaceConfig, error) {
-	for _, namespaceConfig := range n.Namespaces {
-		if namespaceConfig.Name == namespaceName {
+// The file and line a field was defined on
+type position struct {
+	file string
+	line int
+}
 
-			// Apply Defaults to the retrieved namespaceConfig
-			if namespaceConfig.Annotations == nil {
-				namespaceConfig.Annotations = n.DefaultConfig.Annotations
-			}
+func (p position) String() string {
+	if p.line > 0 {
+		return fmt.Sprintf("%s:%d", p.file, p.line)
+	}
+	return p.file
+}
 
-			if namespaceConfig.Labels == nil {
-				namespaceConfig.Labels = n.DefaultConfig.Labels
-			}
+// Gets the config for a specific Knamespace given its name and current labels. An entry with the exact
+// name takes precedence over namePattern and nameGlob entries, which take precedence over namespaceSelector
+// entries. Among namePattern/nameGlob entries and among namespaceSelector entries the first listed match
+// is used. Entries from NamespacePolicies are only considered when no other entry matches. Inherited
+// profiles are merged in, then unset fields are taken from the defaults. When ApplyDefaultsToAll is set,
+// namespaces no entry matches get the defaults unless they are excluded.
+func (n NamespacesConfig) GetConfig(namespaceName string, namespaceLabels map[string]string) (*NamespaceConfig, error) {
+	namespaceConfig := n.findConfig(namespaceName, namespaceLabels)
+	if namespaceConfig == nil && n.ApplyDefaultsToAll && !n.excludedFromDefaults(namespaceName) {
+		namespaceConfig = &NamespaceConfig{
+			Name: namespaceName,
+		}
+	}
+	if namespaceConfig == nil {
+		return nil, fmt.Errorf(fmt.Sprintf("namespace %s not found in configuration", namespaceName))
+	}
 
-			if namespaceConfig.Mode == "" {
-				namespaceConfig.Mode = n.DefaultConfig.Mode
-			}
+	if err := n.applyProfiles(namespaceConfig); err != nil {
+		return nil, err
+	}
+	n.applyDefaults(namespaceConfig)
+	namespaceConfig.ProtectedKeys = n.GetProtectedKeys()
+
+	return namespaceConfig, nil
+}
+
+// Apply Defaults to a namespaceConfig. An entry's mode overrides all of the default modes
+func (n NamespacesConfig) applyDefaults(namespaceConfig *NamespaceConfig) {
+	if n.MergeDefaults {
+		namespaceConfig.Annotations = mergeMaps(n.DefaultConfig.Annotations, namespaceConfig.Annotations)
+		namespaceConfig.Labels = mergeMaps(n.DefaultConfig.Labels, namespaceConfig.Labels)
+		namespaceConfig.KeyModes = mergeMaps(n.DefaultConfig.KeyModes, namespaceConfig.KeyModes)
+	}
+
+	if namespaceConfig.Annotations == nil {
+		namespaceConfig.Annotations = n.DefaultConfig.Annotations
+	}
+
+	if namespaceConfig.Labels == nil {
+		namespaceConfig.Labels = n.DefaultConfig.Labels
+	}
+
+	if namespaceConfig.KeyModes == nil {
+		namespaceConfig.KeyModes = n.DefaultConfig.KeyModes
+	}
+
+	// Keys removed by default are removed everywhere, on top of what the entry removes
+	namespaceConfig.RemoveAnnotations = mergeKeyLists(n.DefaultConfig.RemoveAnnotations, namespaceConfig.RemoveAnnotations)
+	namespaceConfig.RemoveLabels = mergeKeyLists(n.DefaultConfig.RemoveLabels, namespaceConfig.RemoveLabels)
+
+	if namespaceConfig.Recreate == nil {
+		namespaceConfig.Recreate = n.DefaultConfig.Recreate
+	}
+	if namespaceConfig.PreventDeletion == nil {
+		namespaceConfig.PreventDeletion = n.DefaultConfig.PreventDeletion
+	}
+
+	if namespaceConfig.Mode == "" {
+		namespaceConfig.Mode = n.DefaultConfig.Mode
+		if namespaceConfig.AnnotationsMode == "" {
+			namespaceConfig.AnnotationsMode = n.DefaultConfig.AnnotationsMode
+		}
+		if namespaceConfig.LabelsMode == "" {
+			namespaceConfig.LabelsMode = n.DefaultConfig.LabelsMode
+		}
+	}
+}
+
+// Combine two lists of keys or globs, dropping duplicates
+func mergeKeyLists(first []string, second []string) []string {
+	var merged []string
+	seen := make(map[string]bool, len(first)+len(second))
+	for _, key := range append(append([]string{}, first...), second...) {
+		if !seen[key] {
+			seen[key] = true
+			merged = append(merged, key)
+		}
+	}
+	return merged
+}
+
+// The mode to apply to annotations
+func (n NamespaceConfig) GetAnnotationsMode() string {
+	if n.AnnotationsMode != "" {
+		return n.AnnotationsMode
+	}
+	return n.Mode
+}
+
+// Whether the namespace is recreated when it is deleted
+func (n NamespaceConfig) GetRecreate() bool {
+	return n.Recreate == nil || *n.Recreate
+}
+
+// Whether deleting the namespace is denied
+func (n NamespaceConfig) GetPreventDeletion() bool {
+	return n.PreventDeletion != nil && *n.PreventDeletion
+}
+
+// The mode to apply to labels
+func (n NamespaceConfig) GetLabelsMode() string {
+	if n.LabelsMode != "" {
+		return n.LabelsMode
+	}
+	return n.Mode
+}
+
+// Return every protected annotation and label key or glob
+func (n NamespacesConfig) GetProtectedKeys() []string {
+	var protectedKeys []string
+	if !n.DisableDefaultProtectedKeys {
+		protectedKeys = append(protectedKeys, DefaultProtectedKeys...)
+	}
+	return append(protectedKeys, n.ProtectedKeys...)
+}
+
+// Whether a key matches any of a list of keys or globs, such as example.com/* for every key with that prefix
+func KeyMatches(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, key); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the entry that applies to a namespace. Returns a copy so defaults can be applied to it
+func (n NamespacesConfig) findConfig(namespaceName string, namespaceLabels map[string]string) *NamespaceConfig {
+	i := n.MatchingEntry(namespaceName, namespaceLabels)
+	if i < 0 {
+		return nil
+	}
+	namespaceConfig := n.Namespaces[i]
+	return &namespaceConfig
+}
+
+// The index in Namespaces of the entry that applies to a namespace, following the precedence described on
+// GetConfig, or -1 if no entry does
+func (n NamespacesConfig) MatchingEntry(namespaceName string, namespaceLabels map[string]string) int {
+	// A NamespacePolicy can never take over a namespace from the config files, whatever either matches on
+	for _, policy := range []bool{false, true} {
+		if i := n.matchingEntry(namespaceName, namespaceLabels, policy); i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// MatchingEntry among either the NamespacePolicy entries or the other entries
+func (n NamespacesConfig) matchingEntry(namespaceName string, namespaceLabels map[string]string, policy bool) int {
+	for i, namespaceConfig := range n.Namespaces {
+		if namespaceConfig.policy == policy && namespaceConfig.Name != "" && namespaceConfig.Name == namespaceName {
+			return i
+		}
+	}
+	for i, namespaceConfig := range n.Namespaces {
+		if namespaceConfig.policy == policy && namespaceConfig.matchesName(namespaceName) {
+			return i
+		}
+	}
+	for i, namespaceConfig := range n.Namespaces {
+		if namespaceConfig.policy == policy && namespaceConfig.matchesLabels(namespaceLabels) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Where the entry at an index in Namespaces was defined, e.g. the config file it came from
+func (n NamespacesConfig) EntrySource(i int) string {
+	return n.positionOf(fmt.Sprintf("namespaces[%d]", i)).file
+}
+
+// Describe the entry that applies to a namespace and where it was defined, for messages to users. Namespaces no
+// entry matches are described as getting defaultNamespaceSettings.
+func (n NamespacesConfig) DescribeEntry(namespaceName string, namespaceLabels map[string]string) string {
+	fieldPath := "defaultNamespaceSettings"
+	if i := n.MatchingEntry(namespaceName, namespaceLabels); i >= 0 {
+		fieldPath = fmt.Sprintf("namespaces[%d]", i)
+	}
+	if pos := n.positionOf(fieldPath); pos.file != "" {
+		return fmt.Sprintf("%s in %s", fieldPath, pos)
+	}
+	return fieldPath
+}
+
+// Whether a namespace is listed in ExcludeFromDefaults
+func (n NamespacesConfig) excludedFromDefaults(namespaceName string) bool {
+	for _, exclude := range n.ExcludeFromDefaults {
+		if matched, err := path.Match(exclude, namespaceName); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the namePattern or nameGlob of an entry matches a namespace name
+func (n NamespaceConfig) matchesName(namespaceName string) bool {
+	switch {
+	case n.NamePattern != "":
+		matched, err := regexp.MatchString(n.NamePattern, namespaceName)
+		return err == nil && matched
+	case n.NameGlob != "":
+		matched, err := path.Match(n.NameGlob, namespaceName)
+		return err == nil && matched
+	}
+	return false
+}
+
+// Whether the namespaceSelector of an entry matches a namespace's labels
+func (n NamespaceConfig) matchesLabels(namespaceLabels map[string]string) bool {
+	if n.NamespaceSelector == nil {
+		return false
+	}
+	selector, err := n.NamespaceSelector.AsSelector()
+	return err == nil && selector.Matches(labels.Set(namespaceLabels))
+}
 
-			return &namespaceConfig, nil
+// Convert to a labels.Selector
+func (l LabelSelector) AsSelector() (labels.Selector, error) {
+	labelSelector := &metav1.LabelSelector{
+		MatchLabels: l.MatchLabels,
+	}
+	for _, requirement := range l.MatchExpressions {
+		labelSelector.MatchExpressions = append(labelSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      requirement.Key,
+			Operator: metav1.LabelSelectorOperator(requirement.Operator),
+			Values:   requirement.Values,
+		})
+	}
+	return metav1.LabelSelectorAsSelector(labelSelector)
+}
+
+// The field that selects the namespaces an entry applies to and its value
+func (n NamespaceConfig) matchField() (string, string) {
+	switch {
+	case n.NamePattern != "":
+		return "namePattern", n.NamePattern
+	case n.NameGlob != "":
+		return "nameGlob", n.NameGlob
+	case n.NamespaceSelector != nil:
+		selector, _ := n.NamespaceSelector.AsSelector()
+		if selector == nil {
+			return "namespaceSelector", ""
 		}
+		return "namespaceSelector", selector.String()
 	}
-	return nil, fmt.Errorf(fmt.Sprintf("namespace %s not found in configuration", namespaceName))
+	return "name", n.Name
 }
 
 // Return Knamespacer Defaults
@@ -67,18 +434,86 @@ func (n NamespacesConfig) GetDefault() (*NamespaceConfig, error) {
 	return &n.DefaultConfig, nil
 }
 
-// Return NamespacesConfig from Namespaces config file
-// TODO: Validate the config is complete and correct. And that mode is one of the accepted and lower cased
-// Should we introduce mode defaults?
-func GetNamespacesConfig(namespacesConfigFileName string) (*NamespacesConfig, error) {
+// Build a NamespacesConfig from namespace entries that don't come from a file. source names where they came
+// from in validation errors.
+func NewNamespacesConfig(source string, namespaces ...NamespaceConfig) *NamespacesConfig {
+	data := &NamespacesConfig{
+		Namespaces: namespaces,
+		source:     source,
+		positions:  make(map[string]position),
+	}
+	for i := range namespaces {
+		data.positions[fmt.Sprintf("namespaces[%d]", i)] = position{file: source}
+	}
+	return data
+}
+
+// Build a NamespacesConfig from a NamespacePolicy's entries. They rank below the entries of every config
+// file, see GetConfig.
+func NewPolicyNamespacesConfig(source string, namespaces ...NamespaceConfig) *NamespacesConfig {
+	data := NewNamespacesConfig(source, namespaces...)
+	for i := range data.Namespaces {
+		data.Namespaces[i].policy = true
+	}
+	return data
+}
 
-	contents, err := readConfigFile(namespacesConfigFileName)
+// Return NamespacesConfig from Namespaces config files. Each path is a file or a directory whose *.yaml,
+// *.yml and *.json files are all loaded, and everything loaded is merged into one NamespacesConfig. The result is
+// validated and any parse or validation problems are returned as ValidationErrors.
+func GetNamespacesConfig(paths ...string) (*NamespacesConfig, error) {
+	files, err := configFiles(paths)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := parseConfigFileContents(contents)
-	if err != nil {
+	contents := make([]ConfigContents, 0, len(files))
+	for _, file := range files {
+		fileContents, err := readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, ConfigContents{Source: file, Contents: fileContents})
+	}
+
+	return ParseNamespacesConfig(contents...)
+}
+
+// The contents of a config file, or of a config read from somewhere else such as a ConfigMap key
+type ConfigContents struct {
+	// Where the contents came from, used in validation errors
+	Source   string
+	Contents []byte
+}
+
+// Parse, merge and validate config contents the same way GetNamespacesConfig does files
+func ParseNamespacesConfig(contents ...ConfigContents) (*NamespacesConfig, error) {
+	var configs []*NamespacesConfig
+	var errs ValidationErrors
+	var sources []string
+	for _, config := range contents {
+		sources = append(sources, config.Source)
+		data, err := parseConfigFileContents(config.Contents, config.Source)
+		if err != nil {
+			errs = append(errs, parseErrors(config.Source, err)...)
+			continue
+		}
+		configs = append(configs, data)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("no config files given")
+	}
+
+	// Conflicts between the configs don't hide other problems, the config merged without them is validated too
+	data, err := Merge(configs...)
+	errs = appendValidationErrors(errs, err)
+	errs = appendValidationErrors(errs, data.Validate())
+	if len(errs) > 0 {
+		err = errs
+		log.Errorf("Invalid Knamespacer Configuration %s:\n%s", strings.Join(sources, ", "), err)
 		return nil, err
 	}
 
@@ -88,6 +523,67 @@ func GetNamespacesConfig(namespacesConfigFileName string) (*NamespacesConfig, er
 	return data, nil
 }
 
+// Add the ValidationErrors in err, if any, to errs
+func appendValidationErrors(errs ValidationErrors, err error) ValidationErrors {
+	if err == nil {
+		return errs
+	}
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return append(errs, validationErrs...)
+	}
+	return append(errs, ValidationError{Message: err.Error()})
+}
+
+// Expand directories into the config files in them. Hidden files, such as the ..data directory of a
+// mounted ConfigMap, are skipped.
+func configFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no config files given")
+	}
+
+	var files []string
+	for _, configPath := range paths {
+		info, err := os.Stat(configPath)
+		if err != nil {
+			log.Errorf("Error reading Knamespacer config %s : %s", configPath, err)
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, configPath)
+			continue
+		}
+
+		entries, err := os.ReadDir(configPath)
+		if err != nil {
+			log.Errorf("Error reading Knamespacer config directory %s : %s", configPath, err)
+			return nil, err
+		}
+		found := false
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") || !IsConfigFile(entry.Name()) {
+				continue
+			}
+			file := filepath.Join(configPath, entry.Name())
+			if info, err := os.Stat(file); err != nil || info.IsDir() {
+				continue
+			}
+			files = append(files, file)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("no config files found in directory %s", configPath)
+		}
+	}
+	return files, nil
+}
+
+// Whether a file in a config directory is loaded
+func IsConfigFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".yaml" || ext == ".yml" || ext == ".json"
+}
+
 // Read config file, return contents
 func readConfigFile(namespacesConfigFileName string) ([]byte, error) {
 	log.Debugf("Reading Config File %s:", namespacesConfigFileName)
@@ -100,14 +596,77 @@ func readConfigFile(namespacesConfigFileName string) ([]byte, error) {
 	return contents, err
 }
 
-// Unmarshal contents of config file into NamespacesesConfig
-func parseConfigFileContents(contents []byte) (*NamespacesConfig, error) {
-	data := &NamespacesConfig{}
-	err := yaml.UnmarshalStrict(contents, data)
+// Unmarshal contents of config file into NamespacesesConfig. The contents can be a stream of YAML documents,
+// each a fragment of the config that is merged with the others, or JSON.
+func parseConfigFileContents(contents []byte, source string) (*NamespacesConfig, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	// Decode a second time into node trees so validation can point at lines in the file
+	nodeDecoder := yaml.NewDecoder(bytes.NewReader(contents))
+
+	var documents []*NamespacesConfig
+	for {
+		data := &NamespacesConfig{}
+		err := decoder.Decode(data)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			log.Errorf("Error parsing Knamespacer Configuration: %s", err)
+			return nil, err
+		}
+
+		var root yaml.Node
+		if err := nodeDecoder.Decode(&root); err != nil {
+			return nil, err
+		}
+		data.source = source
+		data.positions = make(map[string]position)
+		indexPositions(&root, "", source, data.positions)
+
+		documents = append(documents, data)
+	}
+	if len(documents) == 0 {
+		return &NamespacesConfig{source: source}, nil
+	}
+
+	data, err := Merge(documents...)
 	if err != nil {
-		log.Errorf("Error parsing Knamespacer Configuration: %s", err)
 		return nil, err
 	}
+	data.source = source
+
 	log.Debugf("NamespacesConfig:%#v", data)
 	return data, nil
 }
+
+// Record the line of every mapping key and sequence item under node, keyed by its field path
+// (e.g. namespaces[2].labels[foo])
+func indexPositions(node *yaml.Node, path string, file string, positions map[string]position) {
+	if _, ok := positions[path]; !ok {
+		positions[path] = position{file, node.Line}
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			indexPositions(child, path, file, positions)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			indexPositions(child, fmt.Sprintf("%s[%d]", path, i), file, positions)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			childPath := key.Value
+			if path != "" {
+				childPath = path + "." + key.Value
+				// Map keys are also addressed as path[key] by field.Path
+				positions[fmt.Sprintf("%s[%s]", path, key.Value)] = position{file, key.Line}
+			}
+			positions[childPath] = position{file, key.Line}
+			indexPositions(value, childPath, file, positions)
+		}
+	}
+}
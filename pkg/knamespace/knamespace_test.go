@@ -0,0 +1,783 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package knamespace_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ejether/knamespacer/pkg/knamespace"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "namespaces.yaml")
+	err := os.WriteFile(configFile, []byte(contents), 0o600)
+	assert.Nil(t, err)
+	return configFile
+}
+
+func TestGetNamespacesConfig(t *testing.T) {
+	cfg, err := knamespace.GetNamespacesConfig("../e2e/config.yaml")
+	assert.Nil(t, err)
+	assert.Len(t, cfg.Namespaces, 4)
+
+	four, err := cfg.GetConfig("four", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, knamespace.ModeUpsert, four.Mode)
+	assert.Equal(t, map[string]string{"default": "label"}, four.Labels)
+
+	_, err = cfg.GetConfig("missing", nil)
+	assert.NotNil(t, err)
+}
+
+func TestGetNamespacesConfigUnknownField(t *testing.T) {
+	configFile := writeConfig(t, `
+namespaces:
+  - name: one
+    mode: sync
+    lables: {}
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	assert.NotNil(t, err)
+
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, 5, errs[0].Line)
+}
+
+func TestValidate(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  labels:
+    "bad key!": value
+namespaces:
+  - name: one
+    mode: upsrt
+  - name: one
+    mode: sync
+  - name: Not_A_Namespace
+    mode: insert
+    labels:
+      ok: "bad value!"
+  - mode: sync
+    annotations:
+      "/": value
+  - name: two
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	assert.NotNil(t, err)
+
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+
+	found := make(map[string]int)
+	for _, e := range errs {
+		assert.Equal(t, configFile, e.File)
+		found[e.Field] = e.Line
+	}
+
+	assert.Equal(t, map[string]int{
+		"defaultNamespaceSettings.labels[bad key!]": 3,
+		"namespaces[0].mode":                        6,
+		"namespaces[1].name":                        7,
+		"namespaces[2].name":                        9,
+		"namespaces[2].labels[ok]":                  12,
+		"namespaces[3].name":                        13,
+		"namespaces[3].annotations[/]":              15,
+		"namespaces[4].mode":                        16,
+	}, found)
+}
+
+func TestGetConfigPatterns(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+namespaces:
+  - nameGlob: "team-*"
+    labels:
+      matched: glob
+  - namePattern: "^team-.*-prod$"
+    labels:
+      matched: pattern
+  - name: team-a-prod
+    labels:
+      matched: name
+`)
+	cfg, err := knamespace.GetNamespacesConfig(configFile)
+	assert.Nil(t, err)
+
+	for namespaceName, matched := range map[string]string{
+		"team-a-prod": "name",
+		"team-b-prod": "glob",
+		"team-b-dev":  "glob",
+	} {
+		namespaceConfig, err := cfg.GetConfig(namespaceName, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, matched, namespaceConfig.Labels["matched"], namespaceName)
+		assert.Equal(t, knamespace.ModeUpsert, namespaceConfig.Mode)
+	}
+
+	_, err = cfg.GetConfig("other", nil)
+	assert.NotNil(t, err)
+}
+
+func TestValidatePatterns(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+namespaces:
+  - namePattern: "team-("
+  - nameGlob: "team-["
+  - name: both
+    nameGlob: "both-*"
+  - nameGlob: "dup-*"
+  - nameGlob: "dup-*"
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{
+		"namespaces[0].namePattern",
+		"namespaces[1].nameGlob",
+		"namespaces[2]",
+		"namespaces[4].nameGlob",
+	}, fields)
+}
+
+func TestGetConfigSelector(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+namespaces:
+  - namespaceSelector:
+      matchLabels:
+        tier: prod
+      matchExpressions:
+        - key: team
+          operator: Exists
+    annotations:
+      matched: selector
+  - nameGlob: "glob-*"
+    annotations:
+      matched: glob
+`)
+	cfg, err := knamespace.GetNamespacesConfig(configFile)
+	assert.Nil(t, err)
+
+	namespaceConfig, err := cfg.GetConfig("any", map[string]string{"tier": "prod", "team": "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, "selector", namespaceConfig.Annotations["matched"])
+
+	namespaceConfig, err = cfg.GetConfig("glob-a", map[string]string{"tier": "prod", "team": "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, "glob", namespaceConfig.Annotations["matched"])
+
+	_, err = cfg.GetConfig("any", map[string]string{"tier": "prod"})
+	assert.NotNil(t, err)
+}
+
+func TestGetConfigPolicies(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+namespaces:
+  - nameGlob: "team-*"
+    annotations:
+      matched: file
+  - namespaceSelector:
+      matchLabels:
+        tier: prod
+    annotations:
+      matched: file
+`)
+	cfg, err := knamespace.GetNamespacesConfig(configFile)
+	assert.Nil(t, err)
+	cfg, err = knamespace.Merge(cfg, knamespace.NewPolicyNamespacesConfig("NamespacePolicy/takeover",
+		knamespace.NamespaceConfig{Name: "team-a", Mode: knamespace.ModeSync, Annotations: map[string]string{"matched": "policy"}},
+		knamespace.NamespaceConfig{Name: "app", Mode: knamespace.ModeSync, Annotations: map[string]string{"matched": "policy"}},
+		knamespace.NamespaceConfig{Name: "other", Mode: knamespace.ModeSync, Annotations: map[string]string{"matched": "policy"}},
+	))
+	assert.Nil(t, err)
+
+	// A policy's exact name doesn't win over config file entries matching by glob or selector
+	prod := map[string]string{"tier": "prod"}
+	for _, test := range []struct {
+		name    string
+		labels  map[string]string
+		matched string
+	}{
+		{"team-a", nil, "file"},
+		{"app", prod, "file"},
+		{"app", nil, "policy"},
+		{"other", prod, "file"},
+		{"other", nil, "policy"},
+	} {
+		namespaceConfig, err := cfg.GetConfig(test.name, test.labels)
+		assert.Nil(t, err)
+		assert.Equal(t, test.matched, namespaceConfig.Annotations["matched"], test.name)
+	}
+}
+
+func TestValidateSelector(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+namespaces:
+  - namespaceSelector: {}
+  - namespaceSelector:
+      matchExpressions:
+        - key: tier
+          operator: Equals
+        - key: tier
+          operator: In
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{
+		"namespaces[0].namespaceSelector",
+		"namespaces[1].namespaceSelector.matchExpressions[0].operator",
+		"namespaces[1].namespaceSelector.matchExpressions[1]",
+	}, fields)
+}
+
+func TestGetConfigApplyDefaultsToAll(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+  labels:
+    cost-center: shared
+applyDefaultsToAll: true
+excludeFromDefaults:
+  - kube-system
+  - "openshift-*"
+namespaces:
+  - name: listed
+    labels:
+      cost-center: listed
+`)
+	cfg, err := knamespace.GetNamespacesConfig(configFile)
+	assert.Nil(t, err)
+
+	namespaceConfig, err := cfg.GetConfig("listed", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "listed", namespaceConfig.Labels["cost-center"])
+
+	namespaceConfig, err = cfg.GetConfig("unlisted", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "unlisted", namespaceConfig.Name)
+	assert.Equal(t, knamespace.ModeUpsert, namespaceConfig.Mode)
+	assert.Equal(t, "shared", namespaceConfig.Labels["cost-center"])
+
+	for _, excluded := range []string{"kube-system", "openshift-monitoring"} {
+		_, err = cfg.GetConfig(excluded, nil)
+		assert.NotNil(t, err, excluded)
+	}
+}
+
+func TestProtectedKeys(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+protectedKeys:
+  - "example.com/*"
+namespaces:
+  - name: one
+    labels:
+      example.com/team: one
+      pod-security.kubernetes.io/enforce: restricted
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 2)
+
+	cfg := knamespace.NamespacesConfig{
+		ProtectedKeys:               []string{"example.com/*"},
+		DisableDefaultProtectedKeys: true,
+		Namespaces:                  []knamespace.NamespaceConfig{{Name: "one"}},
+	}
+	namespaceConfig, err := cfg.GetConfig("one", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"example.com/*"}, namespaceConfig.ProtectedKeys)
+	assert.True(t, knamespace.KeyMatches(namespaceConfig.ProtectedKeys, "example.com/team"))
+	assert.False(t, knamespace.KeyMatches(namespaceConfig.ProtectedKeys, "team"))
+}
+
+func TestModes(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  labelsMode: insert
+  annotationsMode: upsert
+namespaces:
+  - name: defaults
+  - name: overridden
+    mode: sync
+  - name: labels-only
+    labelsMode: upsert
+    keyModes:
+      owner: insert
+`)
+	cfg, err := knamespace.GetNamespacesConfig(configFile)
+	assert.Nil(t, err)
+
+	for namespaceName, modes := range map[string][2]string{
+		"defaults":    {knamespace.ModeUpsert, knamespace.ModeInsert},
+		"overridden":  {knamespace.ModeSync, knamespace.ModeSync},
+		"labels-only": {knamespace.ModeUpsert, knamespace.ModeUpsert},
+	} {
+		namespaceConfig, err := cfg.GetConfig(namespaceName, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, modes[0], namespaceConfig.GetAnnotationsMode(), namespaceName)
+		assert.Equal(t, modes[1], namespaceConfig.GetLabelsMode(), namespaceName)
+	}
+
+	configFile = writeConfig(t, `namespaces:
+  - name: one
+    labelsMode: upsert
+  - name: two
+    mode: upsert
+    annotationsMode: merge
+    keyModes:
+      owner: sync
+`)
+	_, err = knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{
+		"namespaces[0].mode",
+		"namespaces[1].annotationsMode",
+		"namespaces[1].keyModes[owner]",
+	}, fields)
+}
+
+func TestRemoveKeys(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+  removeLabels:
+  - deprecated
+namespaces:
+  - name: one
+    removeLabels:
+    - "legacy.example.com/*"
+    - deprecated
+`)
+	cfg, err := knamespace.GetNamespacesConfig(configFile)
+	assert.Nil(t, err)
+
+	namespaceConfig, err := cfg.GetConfig("one", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"deprecated", "legacy.example.com/*"}, namespaceConfig.RemoveLabels)
+
+	configFile = writeConfig(t, `namespaces:
+  - name: one
+    mode: upsert
+    labels:
+      team: platform
+    removeLabels:
+    - "te*"
+    - "[bad"
+    removeAnnotations:
+    - "pod-security.kubernetes.io/enforce"
+`)
+	_, err = knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{
+		"namespaces[0].removeLabels[0]",
+		"namespaces[0].removeLabels[1]",
+		"namespaces[0].removeAnnotations[0]",
+	}, fields)
+}
+
+func TestRemoveKeysInherited(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+  removeLabels:
+  - "legacy-*"
+profiles:
+  cleanup:
+    removeAnnotations:
+    - "old.example.com/*"
+namespaces:
+  - name: one
+    labels:
+      legacy-team: platform
+  - name: two
+    inherits: [cleanup]
+    annotations:
+      old.example.com/owner: platform
+  - name: three
+    labels:
+      team: platform
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "namespaces[0].labels[legacy-team]", errs[0].Field)
+	assert.Contains(t, errs[0].Message, `removed by "legacy-*" in defaultNamespaceSettings.removeLabels`)
+	assert.Equal(t, 12, errs[0].Line)
+	assert.Equal(t, "namespaces[1].annotations[old.example.com/owner]", errs[1].Field)
+	assert.Contains(t, errs[1].Message, `removed by "old.example.com/*" in an inherited profile`)
+}
+
+func TestRender(t *testing.T) {
+	t.Setenv("KNAMESPACER_TEST_CLUSTER", "prod-1")
+	t.Setenv("TEST_SECRET", "hunter2")
+	defaults := map[string]string{"dashboard": "https://grafana/ns/{{ .Name }}"}
+	namespaceConfig := &knamespace.NamespaceConfig{
+		Annotations: defaults,
+		Labels: map[string]string{
+			"owner":   `{{ index .Labels "team" }}`,
+			"cluster": "{{ .Env.KNAMESPACER_TEST_CLUSTER }}",
+			"secret":  "{{ .Env.TEST_SECRET }}",
+			"created": `{{ .CreationTimestamp.Format "2006-01-02" }}`,
+			"missing": `{{ .Labels.missing }}`,
+			"plain":   "value",
+		},
+	}
+
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	err := namespaceConfig.Render(knamespace.NewTemplateData("team-a", map[string]string{"team": "platform"}, nil, created))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"dashboard": "https://grafana/ns/team-a"}, namespaceConfig.Annotations)
+	assert.Equal(t, map[string]string{
+		"owner":   "platform",
+		"cluster": "prod-1",
+		"secret":  "",
+		"created": "2024-03-01",
+		"missing": "",
+		"plain":   "value",
+	}, namespaceConfig.Labels)
+	// Maps shared with the defaults are left alone
+	assert.Equal(t, "https://grafana/ns/{{ .Name }}", defaults["dashboard"])
+
+	namespaceConfig = &knamespace.NamespaceConfig{
+		Labels: map[string]string{"dashboard": "https://grafana/ns/{{ .Name }}"},
+	}
+	err = namespaceConfig.Render(knamespace.NewTemplateData("team-a", nil, nil, created))
+	assert.NotNil(t, err)
+}
+
+func TestValidateTemplates(t *testing.T) {
+	configFile := writeConfig(t, `namespaces:
+  - name: one
+    mode: upsert
+    labels:
+      owner: '{{ index .Labels "team" }}'
+      broken: '{{ .Name'
+    annotations:
+      unknown: '{{ .Namespace }}'
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{
+		"namespaces[0].labels[broken]",
+		"namespaces[0].annotations[unknown]",
+	}, fields)
+}
+
+func TestProfiles(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: insert
+  labels:
+    default: label
+profiles:
+  base:
+    labels:
+      tier: standard
+      team: platform
+    removeLabels:
+    - legacy
+  prod:
+    inherits: [base]
+    mode: upsert
+    labels:
+      tier: prod
+  pci:
+    labels:
+      compliance: pci
+      tier: pci
+    annotations:
+      audit: required
+namespaces:
+  - name: payments
+    inherits: [prod, pci]
+    labels:
+      team: payments
+  - name: reversed
+    inherits: [pci, prod]
+  - name: plain
+`)
+	cfg, err := knamespace.GetNamespacesConfig(configFile)
+	assert.Nil(t, err)
+
+	namespaceConfig, err := cfg.GetConfig("payments", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, knamespace.ModeUpsert, namespaceConfig.Mode)
+	assert.Equal(t, map[string]string{"tier": "pci", "team": "payments", "compliance": "pci"}, namespaceConfig.Labels)
+	assert.Equal(t, map[string]string{"audit": "required"}, namespaceConfig.Annotations)
+	assert.Equal(t, []string{"legacy"}, namespaceConfig.RemoveLabels)
+
+	namespaceConfig, err = cfg.GetConfig("reversed", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"tier": "prod", "team": "platform", "compliance": "pci"}, namespaceConfig.Labels)
+
+	namespaceConfig, err = cfg.GetConfig("plain", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, knamespace.ModeInsert, namespaceConfig.Mode)
+	assert.Equal(t, map[string]string{"default": "label"}, namespaceConfig.Labels)
+}
+
+func TestValidateProfiles(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+profiles:
+  a:
+    inherits: [b]
+  b:
+    inherits: [a]
+  named:
+    name: nope
+namespaces:
+  - name: one
+    inherits: [missing, named]
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{
+		"profiles[a].inherits[0]",
+		"profiles[b].inherits[0]",
+		"profiles[named]",
+		"namespaces[0].inherits[0]",
+	}, fields)
+	assert.Contains(t, errs[0].Message, "a -> b -> a")
+}
+
+func TestMergeDefaults(t *testing.T) {
+	config := `defaultNamespaceSettings:
+  mode: upsert
+  labels:
+    cost-center: shared
+    team: unknown
+namespaces:
+  - name: one
+    labels:
+      team: platform
+`
+	for mergeDefaults, expected := range map[bool]map[string]string{
+		false: {"team": "platform"},
+		true:  {"cost-center": "shared", "team": "platform"},
+	} {
+		contents := config
+		if mergeDefaults {
+			contents = "mergeDefaults: true\n" + contents
+		}
+		cfg, err := knamespace.GetNamespacesConfig(writeConfig(t, contents))
+		assert.Nil(t, err)
+
+		namespaceConfig, err := cfg.GetConfig("one", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, namespaceConfig.Labels)
+		// The defaults themselves are left alone
+		assert.Equal(t, map[string]string{"cost-center": "shared", "team": "unknown"}, cfg.DefaultConfig.Labels)
+	}
+}
+
+func TestGetNamespacesConfigDirectory(t *testing.T) {
+	configDir := t.TempDir()
+	for name, contents := range map[string]string{
+		"00-defaults.yaml": `defaultNamespaceSettings:
+  mode: upsert
+protectedKeys:
+- owner
+`,
+		"team-a.yaml": `namespaces:
+  - name: team-a
+    labels:
+      team: a
+`,
+		"team-b.yml": `profiles:
+  b:
+    labels:
+      team: b
+namespaces:
+  - name: team-b
+    inherits: [b]
+`,
+		"README.md":     "not a config file",
+		".hidden.yaml":  "not: valid",
+		"extra/ignored": "",
+	} {
+		file := filepath.Join(configDir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(file), 0o700))
+		assert.Nil(t, os.WriteFile(file, []byte(contents), 0o600))
+	}
+	extraFile := writeConfig(t, `namespaces:
+  - name: extra
+`)
+
+	cfg, err := knamespace.GetNamespacesConfig(configDir, extraFile)
+	assert.Nil(t, err)
+
+	var names []string
+	for _, namespaceConfig := range cfg.Namespaces {
+		names = append(names, namespaceConfig.Name)
+	}
+	assert.Equal(t, []string{"team-a", "team-b", "extra"}, names)
+	assert.Equal(t, []string{"owner"}, cfg.ProtectedKeys)
+
+	namespaceConfig, err := cfg.GetConfig("team-b", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, knamespace.ModeUpsert, namespaceConfig.Mode)
+	assert.Equal(t, map[string]string{"team": "b"}, namespaceConfig.Labels)
+
+	_, err = knamespace.GetNamespacesConfig(t.TempDir())
+	assert.NotNil(t, err)
+}
+
+func TestGetNamespacesConfigConflicts(t *testing.T) {
+	first := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+profiles:
+  shared: {}
+namespaces:
+  - name: one
+`)
+	second := writeConfig(t, `defaultNamespaceSettings:
+  mode: sync
+profiles:
+  shared: {}
+`)
+	third := writeConfig(t, `namespaces:
+  - name: two
+  - name: one
+`)
+
+	_, err := knamespace.GetNamespacesConfig(first, second)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+	assert.Equal(t, knamespace.ValidationErrors{
+		{File: second, Line: 1, Field: "defaultNamespaceSettings", Message: "already defined in " + first + ":1"},
+		{File: second, Line: 4, Field: "profiles[shared]", Message: "already defined in " + first + ":4"},
+	}, errs)
+
+	_, err = knamespace.GetNamespacesConfig(first, third)
+	errs, ok = err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+	assert.Equal(t, knamespace.ValidationErrors{
+		{File: third, Line: 3, Field: "namespaces[2].name", Message: `Invalid value: "one": duplicate of namespaces[0] in ` + first + ":6"},
+	}, errs)
+}
+
+func TestGetNamespacesConfigConflictsAndInvalid(t *testing.T) {
+	first := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+namespaces:
+  - name: a
+`)
+	second := writeConfig(t, `defaultNamespaceSettings:
+  mode: sync
+namespaces:
+  - name: a
+  - name: b
+    mode: snc
+`)
+
+	// The merge conflict doesn't hide the problems validation finds
+	_, err := knamespace.GetNamespacesConfig(first, second)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+	assert.Equal(t, knamespace.ValidationErrors{
+		{File: second, Line: 1, Field: "defaultNamespaceSettings", Message: "already defined in " + first + ":1"},
+		{File: second, Line: 4, Field: "namespaces[1].name", Message: `Invalid value: "a": duplicate of namespaces[0] in ` + first + ":4"},
+		{File: second, Line: 6, Field: "namespaces[2].mode", Message: `Unsupported value: "snc": supported values: "insert", "managed", "sync", "upsert"`},
+	}, errs)
+}
+
+func TestGetNamespacesConfigDocuments(t *testing.T) {
+	configFile := writeConfig(t, `defaultNamespaceSettings:
+  mode: upsert
+---
+namespaces:
+  - name: one
+---
+# generated
+---
+namespaces:
+  - name: two
+  - name: one
+`)
+	_, err := knamespace.GetNamespacesConfig(configFile)
+	errs, ok := err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+	assert.Equal(t, knamespace.ValidationErrors{
+		{File: configFile, Line: 11, Field: "namespaces[2].name", Message: `Invalid value: "one": duplicate of namespaces[0]`},
+	}, errs)
+
+	configFile = filepath.Join(t.TempDir(), "namespaces.json")
+	err = os.WriteFile(configFile, []byte(`{
+	"defaultNamespaceSettings": {"mode": "upsert"},
+	"namespaces": [
+		{"name": "one", "labels": {"team": "platform"}},
+		{"name": "two", "mode": "bogus"}
+	]
+}
+`), 0o600)
+	assert.Nil(t, err)
+
+	_, err = knamespace.GetNamespacesConfig(configFile)
+	errs, ok = err.(knamespace.ValidationErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, 5, errs[0].Line)
+	assert.Equal(t, "namespaces[1].mode", errs[0].Field)
+
+	cfg, err := knamespace.GetNamespacesConfig(filepath.Dir(configFile))
+	assert.NotNil(t, err)
+	assert.Nil(t, cfg)
+}
@@ -0,0 +1,116 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package knamespace
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches the list a field path starts in and the index in it
+var listPath = regexp.MustCompile(`^(namespaces|protectedKeys|excludeFromDefaults|deletionProtection\.allowedUsers|deletionProtection\.allowedGroups)\[(\d+)\]`)
+
+// Merge combines several NamespacesConfigs, such as the files in a config directory, into one. Namespace
+// entries, protected keys, exclusions and the users and groups allowed to delete protected namespaces are
+// concatenated in order, profiles are combined and a setting enabled in any config is enabled. Only one
+// config may set defaultNamespaceSettings and a profile may only be defined once; a second definition is
+// reported and left out, and the merged config is returned with the errors so it can still be validated.
+// Entries for the same namespace in different configs are reported by Validate.
+func Merge(configs ...*NamespacesConfig) (*NamespacesConfig, error) {
+	if len(configs) == 1 {
+		return configs[0], nil
+	}
+
+	merged := &NamespacesConfig{
+		positions: make(map[string]position),
+	}
+	var errs ValidationErrors
+	var defaultsFrom *NamespacesConfig
+
+	for _, config := range configs {
+		offsets := map[string]int{
+			"namespaces":          len(merged.Namespaces),
+			"protectedKeys":       len(merged.ProtectedKeys),
+			"excludeFromDefaults": len(merged.ExcludeFromDefaults),
+
+			"deletionProtection.allowedUsers":  len(merged.DeletionProtection.AllowedUsers),
+			"deletionProtection.allowedGroups": len(merged.DeletionProtection.AllowedGroups),
+		}
+
+		if !reflect.DeepEqual(config.DefaultConfig, NamespaceConfig{}) {
+			if defaultsFrom != nil {
+				errs = append(errs, config.alreadyDefined("defaultNamespaceSettings", defaultsFrom))
+			} else {
+				merged.DefaultConfig = config.DefaultConfig
+				defaultsFrom = config
+			}
+		}
+
+		for _, profileName := range sortedKeys(config.Profiles) {
+			if _, ok := merged.Profiles[profileName]; ok {
+				errs = append(errs, config.alreadyDefined(fmt.Sprintf("profiles[%s]", profileName), merged))
+				continue
+			}
+			if merged.Profiles == nil {
+				merged.Profiles = make(map[string]NamespaceConfig)
+			}
+			merged.Profiles[profileName] = config.Profiles[profileName]
+		}
+
+		merged.Namespaces = append(merged.Namespaces, config.Namespaces...)
+		merged.ProtectedKeys = append(merged.ProtectedKeys, config.ProtectedKeys...)
+		merged.ExcludeFromDefaults = append(merged.ExcludeFromDefaults, config.ExcludeFromDefaults...)
+		merged.DeletionProtection.AllowedUsers = append(merged.DeletionProtection.AllowedUsers, config.DeletionProtection.AllowedUsers...)
+		merged.DeletionProtection.AllowedGroups = append(merged.DeletionProtection.AllowedGroups, config.DeletionProtection.AllowedGroups...)
+
+		merged.ApplyDefaultsToAll = merged.ApplyDefaultsToAll || config.ApplyDefaultsToAll
+		merged.DisableDefaultProtectedKeys = merged.DisableDefaultProtectedKeys || config.DisableDefaultProtectedKeys
+		merged.MergeDefaults = merged.MergeDefaults || config.MergeDefaults
+
+		// List indexes move along by the entries that came before this config
+		for fieldPath, pos := range config.positions {
+			if match := listPath.FindStringSubmatch(fieldPath); match != nil {
+				index, _ := strconv.Atoi(match[2])
+				fieldPath = fmt.Sprintf("%s[%d]%s", match[1], index+offsets[match[1]], fieldPath[len(match[0]):])
+			} else if _, ok := merged.positions[fieldPath]; ok && !(defaultsFrom == config && strings.HasPrefix(fieldPath, "defaultNamespaceSettings")) {
+				continue
+			}
+			merged.positions[fieldPath] = pos
+		}
+	}
+
+	if len(errs) > 0 {
+		return merged, errs
+	}
+	return merged, nil
+}
+
+// A field defined in this config that another config already defines
+func (n NamespacesConfig) alreadyDefined(fieldPath string, other *NamespacesConfig) ValidationError {
+	pos := n.positionOf(fieldPath)
+	otherPos := other.positionOf(fieldPath)
+	return ValidationError{
+		File:    pos.file,
+		Line:    pos.line,
+		Field:   fieldPath,
+		Message: fmt.Sprintf("already defined in %s", otherPos),
+	}
+}
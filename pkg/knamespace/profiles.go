@@ -0,0 +1,164 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package knamespace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Returned when profiles inherit each other in a loop
+var errProfileCycle = errors.New("profile inheritance cycle")
+
+// Merge the profiles an entry inherits into it. Profiles are merged in the order they are listed, each after
+// the profiles it inherits itself, and the entry is merged last so later settings win.
+func (n NamespacesConfig) applyProfiles(namespaceConfig *NamespaceConfig) error {
+	if len(namespaceConfig.Inherits) == 0 {
+		return nil
+	}
+	merged := &NamespaceConfig{}
+	for _, profileName := range namespaceConfig.Inherits {
+		profile, err := n.resolveProfile(profileName, nil)
+		if err != nil {
+			return err
+		}
+		merged = mergeNamespaceConfigs(merged, profile)
+	}
+	*namespaceConfig = *mergeNamespaceConfigs(merged, namespaceConfig)
+	return nil
+}
+
+// A profile with the profiles it inherits merged into it. chain holds the profiles being resolved to detect cycles
+func (n NamespacesConfig) resolveProfile(profileName string, chain []string) (*NamespaceConfig, error) {
+	for _, name := range chain {
+		if name == profileName {
+			return nil, fmt.Errorf("%w: %s", errProfileCycle, strings.Join(append(chain, profileName), " -> "))
+		}
+	}
+	profile, ok := n.Profiles[profileName]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found in configuration", profileName)
+	}
+
+	chain = append(chain, profileName)
+	merged := &NamespaceConfig{}
+	for _, inherited := range profile.Inherits {
+		inheritedProfile, err := n.resolveProfile(inherited, chain)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeNamespaceConfigs(merged, inheritedProfile)
+	}
+	return mergeNamespaceConfigs(merged, &profile), nil
+}
+
+// Deep merge two NamespaceConfigs. Modes, recreate and preventDeletion set in overlay replace those in base,
+// annotation, label and key mode maps are merged key by key and removal lists are combined. What the entry
+// selects comes from overlay only.
+func mergeNamespaceConfigs(base *NamespaceConfig, overlay *NamespaceConfig) *NamespaceConfig {
+	merged := *overlay
+	merged.Inherits = nil
+
+	merged.Mode = mergeString(base.Mode, overlay.Mode)
+	merged.AnnotationsMode = mergeString(base.AnnotationsMode, overlay.AnnotationsMode)
+	merged.LabelsMode = mergeString(base.LabelsMode, overlay.LabelsMode)
+
+	merged.Annotations = mergeMaps(base.Annotations, overlay.Annotations)
+	merged.Labels = mergeMaps(base.Labels, overlay.Labels)
+	merged.KeyModes = mergeMaps(base.KeyModes, overlay.KeyModes)
+
+	merged.RemoveAnnotations = mergeKeyLists(base.RemoveAnnotations, overlay.RemoveAnnotations)
+	merged.RemoveLabels = mergeKeyLists(base.RemoveLabels, overlay.RemoveLabels)
+
+	if merged.Recreate == nil {
+		merged.Recreate = base.Recreate
+	}
+	if merged.PreventDeletion == nil {
+		merged.PreventDeletion = base.PreventDeletion
+	}
+
+	return &merged
+}
+
+func mergeString(base string, overlay string) string {
+	if overlay != "" {
+		return overlay
+	}
+	return base
+}
+
+// Merge two maps into a new one. Stays nil if both are nil so defaults still apply
+func mergeMaps(base map[string]string, overlay map[string]string) map[string]string {
+	if base == nil && overlay == nil {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(overlay))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overlay {
+		merged[key] = value
+	}
+	return merged
+}
+
+// Validate the profiles and what inherits them
+func (n NamespacesConfig) validateProfiles(protectedKeys []string) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if len(n.DefaultConfig.Inherits) > 0 {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("defaultNamespaceSettings", "inherits"), "defaultNamespaceSettings can't inherit profiles"))
+	}
+
+	for _, profileName := range sortedKeys(n.Profiles) {
+		profile := n.Profiles[profileName]
+		fldPath := field.NewPath("profiles").Key(profileName)
+
+		if profile.Name != "" || profile.NamePattern != "" || profile.NameGlob != "" || profile.NamespaceSelector != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath, "profiles can't set name, namePattern, nameGlob or namespaceSelector"))
+		}
+		allErrs = append(allErrs, validateNamespaceConfig(&profile, fldPath)...)
+		allErrs = append(allErrs, validateNotProtected(&profile, protectedKeys, fldPath)...)
+		allErrs = append(allErrs, n.validateInherits(profile.Inherits, []string{profileName}, fldPath.Child("inherits"))...)
+	}
+
+	for i := range n.Namespaces {
+		allErrs = append(allErrs, n.validateInherits(n.Namespaces[i].Inherits, nil, field.NewPath("namespaces").Index(i).Child("inherits"))...)
+	}
+
+	return allErrs
+}
+
+// Every inherited profile must exist and a profile can't end up inheriting itself
+func (n NamespacesConfig) validateInherits(inherits []string, chain []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, profileName := range inherits {
+		if _, ok := n.Profiles[profileName]; !ok {
+			allErrs = append(allErrs, field.NotFound(fldPath.Index(i), profileName))
+			continue
+		}
+		// Unknown profiles further down are reported where they are inherited
+		if _, err := n.resolveProfile(profileName, chain); errors.Is(err, errProfileCycle) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), profileName, err.Error()))
+		}
+	}
+	return allErrs
+}
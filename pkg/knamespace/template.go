@@ -0,0 +1,140 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package knamespace
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Only environment variables with this prefix are available to templates as Env, so a config or
+// NamespacePolicy can't read secrets or anything else from knamespacer's environment
+const TemplateEnvPrefix = "KNAMESPACER_"
+
+// The values available to Go templates in annotation and label values
+type TemplateData struct {
+	Name              string
+	Labels            map[string]string
+	Annotations       map[string]string
+	CreationTimestamp time.Time
+	Env               map[string]string
+}
+
+// Template data for a namespace, with the environment variables prefixed TemplateEnvPrefix as Env
+func NewTemplateData(name string, labels map[string]string, annotations map[string]string, creationTimestamp time.Time) TemplateData {
+	env := make(map[string]string)
+	for _, variable := range os.Environ() {
+		if key, value, ok := strings.Cut(variable, "="); ok && strings.HasPrefix(key, TemplateEnvPrefix) {
+			env[key] = value
+		}
+	}
+	return TemplateData{
+		Name:              name,
+		Labels:            labels,
+		Annotations:       annotations,
+		CreationTimestamp: creationTimestamp,
+		Env:               env,
+	}
+}
+
+// Render executes the templates in the annotation and label values for a namespace. The maps are replaced
+// rather than modified since they may be shared with the defaults.
+func (n *NamespaceConfig) Render(data TemplateData) error {
+	annotations, err := renderValues(n.Annotations, data)
+	if err != nil {
+		return fmt.Errorf("rendering annotations for %s: %w", data.Name, err)
+	}
+	labels, err := renderValues(n.Labels, data)
+	if err != nil {
+		return fmt.Errorf("rendering labels for %s: %w", data.Name, err)
+	}
+	for _, key := range sortedKeys(labels) {
+		if msgs := validation.IsValidLabelValue(labels[key]); len(msgs) > 0 {
+			return fmt.Errorf("rendering labels for %s: %s: %q is not a valid label value: %s", data.Name, key, labels[key], strings.Join(msgs, "; "))
+		}
+	}
+
+	n.Annotations = annotations
+	n.Labels = labels
+	return nil
+}
+
+// Whether an annotation or label value is a template
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// Missing map keys, such as a label the namespace doesn't have, render as empty strings
+func parseTemplate(key string, value string) (*template.Template, error) {
+	return template.New(key).Option("missingkey=zero").Parse(value)
+}
+
+func renderValues(values map[string]string, data TemplateData) (map[string]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+	rendered := make(map[string]string, len(values))
+	for key, value := range values {
+		if !isTemplate(value) {
+			rendered[key] = value
+			continue
+		}
+		tmpl, err := parseTemplate(key, value)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		rendered[key] = out.String()
+	}
+	return rendered, nil
+}
+
+// Data templates are executed against when validating them
+var sampleTemplateData = TemplateData{
+	Name:        "example",
+	Labels:      map[string]string{},
+	Annotations: map[string]string{},
+	Env:         map[string]string{},
+}
+
+// Templates must parse and execute. Label values are checked once they are rendered for a real namespace.
+func validateTemplates(values map[string]string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, key := range sortedKeys(values) {
+		if !isTemplate(values[key]) {
+			continue
+		}
+		tmpl, err := parseTemplate(key, values[key])
+		if err == nil {
+			err = tmpl.Execute(&strings.Builder{}, sampleTemplateData)
+		}
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(key), values[key], err.Error()))
+		}
+	}
+	return allErrs
+}
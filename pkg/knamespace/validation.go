@@ -0,0 +1,462 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package knamespace
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// A single problem found in a NamespacesConfig
+type ValidationError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (v ValidationError) Error() string {
+	location := v.File
+	if v.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, v.Line)
+	}
+	message := v.Message
+	if v.Field != "" {
+		message = fmt.Sprintf("%s: %s", v.Field, v.Message)
+	}
+	if location == "" {
+		return message
+	}
+	return fmt.Sprintf("%s: %s", location, message)
+}
+
+// Every problem found in a NamespacesConfig
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, err := range v {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Matches a map key in a field path that is followed by more of the path
+var mapKeyPath = regexp.MustCompile(`\[([^\]]*[^\]0-9][^\]]*)\]([.\[])`)
+
+// Matches the line number yaml adds to its error messages
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// Convert an error from the yaml decoder into ValidationErrors so parse and validation problems are reported alike
+func parseErrors(source string, err error) ValidationErrors {
+	// Conflicts between documents in a file are already ValidationErrors
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return validationErrs
+	}
+
+	var messages []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	errs := make(ValidationErrors, 0, len(messages))
+	for _, message := range messages {
+		parseErr := ValidationError{File: source, Message: message}
+		if match := yamlErrorLine.FindStringSubmatch(message); match != nil {
+			parseErr.Line, _ = strconv.Atoi(match[1])
+			parseErr.Message = match[2]
+		}
+		errs = append(errs, parseErr)
+	}
+	return errs
+}
+
+// Validate checks the NamespacesConfig for mistakes the YAML decoder can't catch: unknown modes,
+// duplicate or invalid namespace names and invalid label and annotation keys and values.
+// All problems are returned together as ValidationErrors.
+func (n NamespacesConfig) Validate() error {
+	var allErrs field.ErrorList
+
+	protectedKeys := n.GetProtectedKeys()
+	for i, key := range n.ProtectedKeys {
+		if _, err := path.Match(key, ""); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("protectedKeys").Index(i), key, err.Error()))
+		}
+	}
+
+	allErrs = append(allErrs, validateNamespaceConfig(&n.DefaultConfig, field.NewPath("defaultNamespaceSettings"))...)
+	allErrs = append(allErrs, validateNotProtected(&n.DefaultConfig, protectedKeys, field.NewPath("defaultNamespaceSettings"))...)
+
+	allErrs = append(allErrs, n.validateProfiles(protectedKeys)...)
+
+	seen := make(map[string]*field.Path)
+	for i := range n.Namespaces {
+		namespaceConfig := &n.Namespaces[i]
+		fldPath := field.NewPath("namespaces").Index(i)
+
+		allErrs = append(allErrs, validateNamespaceConfig(namespaceConfig, fldPath)...)
+		allErrs = append(allErrs, validateNamespaceMatch(namespaceConfig, fldPath)...)
+		allErrs = append(allErrs, validateNotProtected(namespaceConfig, protectedKeys, fldPath)...)
+		allErrs = append(allErrs, n.validateInheritedRemovals(namespaceConfig, fldPath)...)
+
+		matchField, matchValue := namespaceConfig.matchField()
+		if first, ok := seen[matchField+"="+matchValue]; ok && matchValue != "" {
+			message := fmt.Sprintf("duplicate of %s", first)
+			// Entries can come from different files
+			if pos := n.positionOf(first.String()); pos.file != n.positionOf(fldPath.String()).file {
+				message = fmt.Sprintf("%s in %s", message, pos)
+			}
+			allErrs = append(allErrs, field.Invalid(fldPath.Child(matchField), matchValue, message))
+		} else {
+			seen[matchField+"="+matchValue] = fldPath
+		}
+
+		if !n.hasModes(*namespaceConfig) {
+			allErrs = append(allErrs, field.Required(fldPath.Child("mode"), "must be set here or in defaultNamespaceSettings"))
+		}
+	}
+
+	if n.ApplyDefaultsToAll && !n.hasModes(NamespaceConfig{}) {
+		allErrs = append(allErrs, field.Required(field.NewPath("defaultNamespaceSettings", "mode"), "must be set when applyDefaultsToAll is enabled"))
+	}
+	for i, exclude := range n.ExcludeFromDefaults {
+		if _, err := path.Match(exclude, ""); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("excludeFromDefaults").Index(i), exclude, err.Error()))
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return n.validationErrors(allErrs)
+}
+
+// Whether an entry ends up with a mode for both its annotations and labels once defaults are applied
+func (n NamespacesConfig) hasModes(namespaceConfig NamespaceConfig) bool {
+	// Problems with inherited profiles are reported separately
+	_ = n.applyProfiles(&namespaceConfig)
+	n.applyDefaults(&namespaceConfig)
+	return namespaceConfig.GetAnnotationsMode() != "" && namespaceConfig.GetLabelsMode() != ""
+}
+
+// Convert a field.ErrorList into ValidationErrors pointing at the source of each field
+func (n NamespacesConfig) validationErrors(allErrs field.ErrorList) ValidationErrors {
+	errs := make(ValidationErrors, 0, len(allErrs))
+	for _, err := range allErrs {
+		pos := n.positionOf(err.Field)
+		errs = append(errs, ValidationError{
+			File:    pos.file,
+			Line:    pos.line,
+			Field:   err.Field,
+			Message: err.ErrorBody(),
+		})
+	}
+	return errs
+}
+
+// Find where a field was defined, falling back to its closest parent
+func (n NamespacesConfig) positionOf(path string) position {
+	// Fields below a map key, such as profiles[prod].labels, are indexed as profiles.prod.labels
+	path = mapKeyPath.ReplaceAllString(path, ".$1$2")
+	for path != "" {
+		if pos, ok := n.positions[path]; ok {
+			return pos
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return position{file: n.source}
+}
+
+// Validate the fields shared by namespace entries and the defaults
+func validateNamespaceConfig(namespaceConfig *NamespaceConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for _, mode := range []struct {
+		name  string
+		value string
+	}{
+		{"mode", namespaceConfig.Mode},
+		{"annotationsMode", namespaceConfig.AnnotationsMode},
+		{"labelsMode", namespaceConfig.LabelsMode},
+	} {
+		if mode.value != "" && !isMode(mode.value) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child(mode.name), mode.value, Modes))
+		}
+	}
+	for _, key := range sortedKeys(namespaceConfig.KeyModes) {
+		mode := namespaceConfig.KeyModes[key]
+		if mode != ModeInsert && mode != ModeUpsert {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("keyModes").Key(key), mode, KeyModes))
+		}
+	}
+
+	allErrs = append(allErrs, validateLabels(namespaceConfig.Labels, fldPath.Child("labels"))...)
+	allErrs = append(allErrs, validateAnnotations(namespaceConfig.Annotations, fldPath.Child("annotations"))...)
+	allErrs = append(allErrs, validateRemovedKeys(namespaceConfig.RemoveAnnotations, namespaceConfig.Annotations, fldPath.Child("removeAnnotations"))...)
+	allErrs = append(allErrs, validateRemovedKeys(namespaceConfig.RemoveLabels, namespaceConfig.Labels, fldPath.Child("removeLabels"))...)
+
+	return allErrs
+}
+
+// Validate a list of keys or globs to remove. A key can't be both set and removed by the same entry
+func validateRemovedKeys(removeKeys []string, metaObject map[string]string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, pattern := range removeKeys {
+		if _, err := path.Match(pattern, ""); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), pattern, err.Error()))
+			continue
+		}
+		for _, key := range sortedKeys(metaObject) {
+			if KeyMatches([]string{pattern}, key) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Index(i), pattern, fmt.Sprintf("removes configured key %q", key)))
+			}
+		}
+	}
+	return allErrs
+}
+
+// Keys an entry or its profiles set that the removal lists of the defaults or the profiles, which the entry's
+// own are merged with, remove again. Keys the entry both sets and removes itself are reported by
+// validateRemovedKeys.
+func (n NamespacesConfig) validateInheritedRemovals(namespaceConfig *NamespaceConfig, fldPath *field.Path) field.ErrorList {
+	configured := *namespaceConfig
+	if err := n.applyProfiles(&configured); err != nil {
+		// Reported with the profiles
+		return nil
+	}
+	merged := configured
+	n.applyDefaults(&merged)
+
+	var allErrs field.ErrorList
+	for _, meta := range []struct {
+		name           string
+		removeField    string
+		values         map[string]string
+		own            map[string]string
+		removeKeys     []string
+		ownRemoveKeys  []string
+		defaultRemoves []string
+	}{
+		{"annotations", "removeAnnotations", configured.Annotations, namespaceConfig.Annotations, merged.RemoveAnnotations, namespaceConfig.RemoveAnnotations, n.DefaultConfig.RemoveAnnotations},
+		{"labels", "removeLabels", configured.Labels, namespaceConfig.Labels, merged.RemoveLabels, namespaceConfig.RemoveLabels, n.DefaultConfig.RemoveLabels},
+	} {
+		for _, key := range sortedKeys(meta.values) {
+			for _, pattern := range meta.removeKeys {
+				if !KeyMatches([]string{pattern}, key) {
+					continue
+				}
+				origin := "an inherited profile"
+				if slices.Contains(meta.ownRemoveKeys, pattern) {
+					if _, ok := meta.own[key]; ok {
+						continue
+					}
+					origin = fldPath.Child(meta.removeField).String()
+				} else if slices.Contains(meta.defaultRemoves, pattern) {
+					origin = field.NewPath("defaultNamespaceSettings", meta.removeField).String()
+				}
+				allErrs = append(allErrs, field.Invalid(fldPath.Child(meta.name).Key(key), meta.values[key], fmt.Sprintf("removed by %q in %s", pattern, origin)))
+				break
+			}
+		}
+	}
+	return allErrs
+}
+
+// Configuring a protected key would silently have no effect
+func validateNotProtected(namespaceConfig *NamespaceConfig, protectedKeys []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, meta := range []struct {
+		name   string
+		values map[string]string
+	}{
+		{"annotations", namespaceConfig.Annotations},
+		{"labels", namespaceConfig.Labels},
+	} {
+		for _, key := range sortedKeys(meta.values) {
+			if KeyMatches(protectedKeys, key) {
+				allErrs = append(allErrs, field.Forbidden(fldPath.Child(meta.name).Key(key), "key is protected and is never changed"))
+			}
+		}
+	}
+	for _, remove := range []struct {
+		name string
+		keys []string
+	}{
+		{"removeAnnotations", namespaceConfig.RemoveAnnotations},
+		{"removeLabels", namespaceConfig.RemoveLabels},
+	} {
+		for i, key := range remove.keys {
+			if KeyMatches(protectedKeys, key) {
+				allErrs = append(allErrs, field.Forbidden(fldPath.Child(remove.name).Index(i), "key is protected and is never removed"))
+			}
+		}
+	}
+	return allErrs
+}
+
+// Validate that an entry sets exactly one of name, namePattern, nameGlob or namespaceSelector and that it is well formed
+func validateNamespaceMatch(namespaceConfig *NamespaceConfig, fldPath *field.Path) field.ErrorList {
+	set := 0
+	for _, value := range []string{namespaceConfig.Name, namespaceConfig.NamePattern, namespaceConfig.NameGlob} {
+		if value != "" {
+			set++
+		}
+	}
+	if namespaceConfig.NamespaceSelector != nil {
+		set++
+	}
+	if set > 1 {
+		return field.ErrorList{field.Forbidden(fldPath, "only one of name, namePattern, nameGlob or namespaceSelector may be set")}
+	}
+
+	switch {
+	case namespaceConfig.NamePattern != "":
+		if _, err := regexp.Compile(namespaceConfig.NamePattern); err != nil {
+			return field.ErrorList{field.Invalid(fldPath.Child("namePattern"), namespaceConfig.NamePattern, err.Error())}
+		}
+	case namespaceConfig.NameGlob != "":
+		if _, err := path.Match(namespaceConfig.NameGlob, ""); err != nil {
+			return field.ErrorList{field.Invalid(fldPath.Child("nameGlob"), namespaceConfig.NameGlob, err.Error())}
+		}
+	case namespaceConfig.NamespaceSelector != nil:
+		return validateLabelSelector(namespaceConfig.NamespaceSelector, fldPath.Child("namespaceSelector"))
+	default:
+		return validateNamespaceName(namespaceConfig.Name, fldPath.Child("name"))
+	}
+	return nil
+}
+
+// A namespaceSelector must be a valid, non-empty label selector
+func validateLabelSelector(selector *LabelSelector, fldPath *field.Path) field.ErrorList {
+	if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		return field.ErrorList{field.Required(fldPath, "matchLabels or matchExpressions is required")}
+	}
+
+	allErrs := validateLabels(selector.MatchLabels, fldPath.Child("matchLabels"))
+	for i, requirement := range selector.MatchExpressions {
+		reqPath := fldPath.Child("matchExpressions").Index(i)
+		if !isOperator(requirement.Operator) {
+			allErrs = append(allErrs, field.NotSupported(reqPath.Child("operator"), requirement.Operator, selectorOperators))
+			continue
+		}
+		if _, err := (LabelSelector{MatchExpressions: []LabelSelectorRequirement{requirement}}).AsSelector(); err != nil {
+			allErrs = append(allErrs, field.Invalid(reqPath, requirement.Key, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// Namespace names must be DNS-1123 labels
+func validateNamespaceName(name string, fldPath *field.Path) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(fldPath, "one of name, namePattern, nameGlob or namespaceSelector is required")}
+	}
+
+	var allErrs field.ErrorList
+	for _, msg := range validation.IsDNS1123Label(name) {
+		allErrs = append(allErrs, field.Invalid(fldPath, name, msg))
+	}
+	return allErrs
+}
+
+// Label keys must be qualified names and values valid label values
+func validateLabels(labels map[string]string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, key := range sortedKeys(labels) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(key), key, msg))
+		}
+		if isTemplate(labels[key]) {
+			continue
+		}
+		for _, msg := range validation.IsValidLabelValue(labels[key]) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(key), labels[key], msg))
+		}
+	}
+	return append(allErrs, validateTemplates(labels, fldPath)...)
+}
+
+// Annotation keys must be qualified names and the annotations must fit within the API server's size limit
+func validateAnnotations(annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, key := range sortedKeys(annotations) {
+		for _, msg := range validation.IsQualifiedName(strings.ToLower(key)) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(key), key, msg))
+		}
+	}
+	if err := apivalidation.ValidateAnnotationsSize(annotations); err != nil {
+		allErrs = append(allErrs, field.TooLong(fldPath, "", apivalidation.TotalAnnotationSizeLimitB))
+	}
+	return append(allErrs, validateTemplates(annotations, fldPath)...)
+}
+
+// Label selector operators accepted in matchExpressions
+var selectorOperators = []metav1.LabelSelectorOperator{
+	metav1.LabelSelectorOpIn,
+	metav1.LabelSelectorOpNotIn,
+	metav1.LabelSelectorOpExists,
+	metav1.LabelSelectorOpDoesNotExist,
+}
+
+func isOperator(operator string) bool {
+	for _, op := range selectorOperators {
+		if operator == string(op) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMode(mode string) bool {
+	for _, m := range Modes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
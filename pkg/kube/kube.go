@@ -19,14 +19,18 @@ package kube
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/ejether/knamespacer/pkg/kube/ownership"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -36,8 +40,22 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
+// The field manager Knamespacer's server-side applies are made as
+const FieldManager = "knamespacer"
+
+// Annotation and label keys whose value the config enforces
+type EnforcedKeys struct {
+	Labels      map[string]bool
+	Annotations map[string]bool
+}
+
 type K8sClient struct {
 	K8s client.Client
+	// Send writes as server-side dry runs so nothing is changed in the cluster
+	DryRun bool
+	// Take ownership of annotations and labels other field managers own instead of failing with a conflict,
+	// even of keys the config doesn't enforce
+	ForceOwnership bool
 }
 
 func NewK8sClient() *K8sClient {
@@ -132,14 +150,24 @@ func (c *K8sClient) CreateNamespace(namespaceName string) error {
 		}
 	}
 
-	err := c.K8s.Create(context.Background(), namespace)
+	var opts []client.CreateOption
+	if c.DryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+
+	err := c.K8s.Create(context.Background(), namespace, opts...)
 
 	return err
 }
 
 // Modify the Metadata of the specified Namespace
 func (c *K8sClient) UpdateNamespace(namespace *corev1.Namespace) error {
-	err := c.K8s.Update(context.TODO(), namespace)
+	var opts []client.UpdateOption
+	if c.DryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+
+	err := c.K8s.Update(context.TODO(), namespace, opts...)
 	if err != nil {
 		log.Errorf("Could not update namespace %s: %s", namespace.Name, err)
 		return err
@@ -147,6 +175,112 @@ func (c *K8sClient) UpdateNamespace(namespace *corev1.Namespace) error {
 	return nil
 }
 
+// Set the annotations and labels of a namespace to the desired ones using server-side apply as FieldManager.
+// Only enforced keys, keys Knamespacer already owns and keys that need to change are applied, so other keys
+// that already have the desired value stay owned by whoever set them. Keys on the namespace that are not
+// desired are removed, as the config asks for, whoever owns them. Likewise enforced keys another field
+// manager changed are taken back. Changing any other key another field manager owns is a conflict unless
+// ForceOwnership is set.
+func (c *K8sClient) ApplyNamespaceMetadata(namespace *corev1.Namespace, labels map[string]string, annotations map[string]string, enforced EnforcedKeys) error {
+	ownedLabels, ownedAnnotations := ownership.ManagedKeys(namespace, FieldManager)
+	otherLabels, otherAnnotations := ownership.OtherManagersKeys(namespace, FieldManager)
+	force := c.ForceOwnership
+
+	metadata := map[string]interface{}{
+		"name": namespace.Name,
+	}
+	// Enforced keys are applied even when they already have the desired value, for instance because the creation
+	// webhook set them, so Knamespacer owns them and can change or, in managed mode, remove them later
+	applyLabels := ownership.ApplyKeys(namespace.Labels, labels, withKeys(ownedLabels, enforced.Labels))
+	if len(applyLabels) > 0 {
+		metadata["labels"] = applyLabels
+	}
+	applyAnnotations := ownership.ApplyKeys(namespace.Annotations, annotations, withKeys(ownedAnnotations, enforced.Annotations))
+	if len(applyAnnotations) > 0 {
+		metadata["annotations"] = applyAnnotations
+	}
+	// A single apply is forced or not as a whole, so it is only forced when every conflict is an enforced key
+	conflicts, unenforced := countConflicts(ownership.Conflicts(namespace.Labels, applyLabels, otherLabels), enforced.Labels)
+	annotationConflicts, unenforcedAnnotations := countConflicts(ownership.Conflicts(namespace.Annotations, applyAnnotations, otherAnnotations), enforced.Annotations)
+	if conflicts+annotationConflicts > 0 && unenforced+unenforcedAnnotations == 0 {
+		force = true
+	}
+
+	apply := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   metadata,
+		},
+	}
+
+	opts := []client.PatchOption{client.FieldOwner(FieldManager)}
+	if force {
+		opts = append(opts, client.ForceOwnership)
+	}
+	if c.DryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+
+	err := c.K8s.Patch(context.TODO(), apply, client.Apply, opts...)
+	if apierrors.IsConflict(err) {
+		return fmt.Errorf("conflict applying metadata to namespace %s, another field manager owns the keys: %w", namespace.Name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("could not apply metadata to namespace %s: %w", namespace.Name, err)
+	}
+
+	// Keys owned by other field managers are not removed by leaving them out of the apply. The config asks
+	// for these to go so they are removed whoever owns them.
+	removeLabels := ownership.RemovedKeys(namespace.Labels, labels)
+	removeAnnotations := ownership.RemovedKeys(namespace.Annotations, annotations)
+	if len(removeLabels) == 0 && len(removeAnnotations) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      removeLabels,
+			"annotations": removeAnnotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var removeOpts []client.PatchOption
+	if c.DryRun {
+		removeOpts = append(removeOpts, client.DryRunAll)
+	}
+	err = c.K8s.Patch(context.TODO(), namespace.DeepCopy(), client.RawPatch(types.MergePatchType, patch), removeOpts...)
+	if err != nil {
+		return fmt.Errorf("could not remove metadata from namespace %s: %w", namespace.Name, err)
+	}
+	return nil
+}
+
+// The keys in either set
+func withKeys(keys map[string]bool, more map[string]bool) map[string]bool {
+	union := make(map[string]bool, len(keys)+len(more))
+	for key := range keys {
+		union[key] = true
+	}
+	for key := range more {
+		union[key] = true
+	}
+	return union
+}
+
+// How many keys conflict and how many of those the config doesn't enforce
+func countConflicts(conflicts map[string]bool, enforced map[string]bool) (total int, unenforced int) {
+	for key := range conflicts {
+		if !enforced[key] {
+			unenforced++
+		}
+	}
+	return len(conflicts), unenforced
+}
+
 // Retrieve corev1.Namespace from cluster
 func (c *K8sClient) GetClusterNamespace(namespaceName string) (*corev1.Namespace, error) {
 	namespace := &corev1.Namespace{}
@@ -0,0 +1,153 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package ownership works out which annotation and label keys of a namespace each field manager owns through
+// server-side apply, and from that which keys an apply should include and which keys have to be removed.
+package ownership
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Find the annotation and label keys of a namespace that a field manager owns through server-side apply
+func ManagedKeys(namespace *corev1.Namespace, manager string) (labels map[string]bool, annotations map[string]bool) {
+	labels = make(map[string]bool)
+	annotations = make(map[string]bool)
+
+	for _, entry := range namespace.ManagedFields {
+		if !isApplyEntry(entry, manager) {
+			continue
+		}
+		entryLabels, entryAnnotations := managedFieldsKeys(namespace, entry)
+		mergeKeys(labels, entryLabels)
+		mergeKeys(annotations, entryAnnotations)
+	}
+	return labels, annotations
+}
+
+// Find the annotation and label keys of a namespace that a field manager owns through server-side apply
+// and that no other field manager also owns
+func SolelyManagedKeys(namespace *corev1.Namespace, manager string) (labels map[string]bool, annotations map[string]bool) {
+	labels, annotations = ManagedKeys(namespace, manager)
+	otherLabels, otherAnnotations := OtherManagersKeys(namespace, manager)
+	for key := range otherLabels {
+		delete(labels, key)
+	}
+	for key := range otherAnnotations {
+		delete(annotations, key)
+	}
+	return labels, annotations
+}
+
+// Find the annotation and label keys of a namespace that any field manager other than the given server-side
+// apply manager owns
+func OtherManagersKeys(namespace *corev1.Namespace, manager string) (labels map[string]bool, annotations map[string]bool) {
+	labels = make(map[string]bool)
+	annotations = make(map[string]bool)
+
+	for _, entry := range namespace.ManagedFields {
+		if isApplyEntry(entry, manager) {
+			continue
+		}
+		entryLabels, entryAnnotations := managedFieldsKeys(namespace, entry)
+		mergeKeys(labels, entryLabels)
+		mergeKeys(annotations, entryAnnotations)
+	}
+	return labels, annotations
+}
+
+func isApplyEntry(entry metav1.ManagedFieldsEntry, manager string) bool {
+	return entry.Manager == manager && entry.Operation == metav1.ManagedFieldsOperationApply
+}
+
+// Parse the annotation and label keys out of a managed fields entry
+func managedFieldsKeys(namespace *corev1.Namespace, entry metav1.ManagedFieldsEntry) (labels map[string]bool, annotations map[string]bool) {
+	labels = make(map[string]bool)
+	annotations = make(map[string]bool)
+	if entry.FieldsV1 == nil {
+		return labels, annotations
+	}
+
+	fields := struct {
+		Metadata struct {
+			Labels      map[string]json.RawMessage `json:"f:labels"`
+			Annotations map[string]json.RawMessage `json:"f:annotations"`
+		} `json:"f:metadata"`
+	}{}
+	if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+		log.Errorf("Could not parse managed fields of namespace %s: %s", namespace.Name, err)
+		return labels, annotations
+	}
+
+	for key := range fields.Metadata.Labels {
+		if strings.HasPrefix(key, "f:") {
+			labels[strings.TrimPrefix(key, "f:")] = true
+		}
+	}
+	for key := range fields.Metadata.Annotations {
+		if strings.HasPrefix(key, "f:") {
+			annotations[strings.TrimPrefix(key, "f:")] = true
+		}
+	}
+	return labels, annotations
+}
+
+func mergeKeys(into map[string]bool, from map[string]bool) {
+	for key := range from {
+		into[key] = true
+	}
+}
+
+// The desired keys to include in a server-side apply: those already owned and those that need to change
+func ApplyKeys(current map[string]string, desired map[string]string, owned map[string]bool) map[string]interface{} {
+	apply := make(map[string]interface{})
+	for key, value := range desired {
+		currentValue, ok := current[key]
+		if owned[key] || !ok || currentValue != value {
+			apply[key] = value
+		}
+	}
+	return apply
+}
+
+// Keys that are present but not desired, as null merge patch values that remove them
+func RemovedKeys(current map[string]string, desired map[string]string) map[string]interface{} {
+	removed := make(map[string]interface{})
+	for key := range current {
+		if _, ok := desired[key]; !ok {
+			removed[key] = nil
+		}
+	}
+	return removed
+}
+
+// Keys an apply sets to a different value than they have that another field manager owns. They are
+// conflicts unless the apply is forced.
+func Conflicts(current map[string]string, apply map[string]interface{}, others map[string]bool) map[string]bool {
+	conflicts := make(map[string]bool)
+	for key, value := range apply {
+		if currentValue, ok := current[key]; ok && currentValue != value && others[key] {
+			conflicts[key] = true
+		}
+	}
+	return conflicts
+}
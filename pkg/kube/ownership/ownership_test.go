@@ -0,0 +1,138 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ownership_test
+
+import (
+	"testing"
+
+	"github.com/ejether/knamespacer/pkg/kube/ownership"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const manager = "knamespacer"
+
+func testNamespace() *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alpha",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:   manager,
+					Operation: metav1.ManagedFieldsOperationApply,
+					FieldsV1: &metav1.FieldsV1{
+						Raw: []byte(`{"f:metadata":{"f:labels":{".":{},"f:team":{}},"f:annotations":{"f:owner":{}}}}`),
+					},
+				},
+				{
+					Manager:   "kubectl-label",
+					Operation: metav1.ManagedFieldsOperationUpdate,
+					FieldsV1: &metav1.FieldsV1{
+						Raw: []byte(`{"f:metadata":{"f:labels":{"f:tier":{},"f:team":{}}}}`),
+					},
+				},
+				{
+					// An update by the same manager isn't an apply
+					Manager:   manager,
+					Operation: metav1.ManagedFieldsOperationUpdate,
+					FieldsV1: &metav1.FieldsV1{
+						Raw: []byte(`{"f:metadata":{"f:annotations":{"f:note":{}}}}`),
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestManagedKeys(t *testing.T) {
+	namespace := testNamespace()
+
+	labels, annotations := ownership.ManagedKeys(namespace, manager)
+	assert.Equal(t, map[string]bool{"team": true}, labels)
+	assert.Equal(t, map[string]bool{"owner": true}, annotations)
+
+	labels, annotations = ownership.SolelyManagedKeys(namespace, manager)
+	assert.Equal(t, map[string]bool{}, labels)
+	assert.Equal(t, map[string]bool{"owner": true}, annotations)
+
+	labels, annotations = ownership.OtherManagersKeys(namespace, manager)
+	assert.Equal(t, map[string]bool{"tier": true, "team": true}, labels)
+	assert.Equal(t, map[string]bool{"note": true}, annotations)
+}
+
+func TestApplyKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  map[string]string
+		desired  map[string]string
+		owned    map[string]bool
+		expected map[string]interface{}
+	}{
+		{"nothing desired", map[string]string{"a": "1"}, nil, nil, map[string]interface{}{}},
+		{"missing key", nil, map[string]string{"a": "1"}, nil, map[string]interface{}{"a": "1"}},
+		{"changed value", map[string]string{"a": "1"}, map[string]string{"a": "2"}, nil, map[string]interface{}{"a": "2"}},
+		{"unchanged and not owned", map[string]string{"a": "1"}, map[string]string{"a": "1"}, nil, map[string]interface{}{}},
+		{"unchanged and owned", map[string]string{"a": "1"}, map[string]string{"a": "1"}, map[string]bool{"a": true}, map[string]interface{}{"a": "1"}},
+		{"owned but not desired", map[string]string{"a": "1"}, map[string]string{}, map[string]bool{"a": true}, map[string]interface{}{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ownership.ApplyKeys(test.current, test.desired, test.owned))
+		})
+	}
+}
+
+func TestRemovedKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  map[string]string
+		desired  map[string]string
+		expected map[string]interface{}
+	}{
+		{"nothing present", nil, map[string]string{"a": "1"}, map[string]interface{}{}},
+		{"all desired", map[string]string{"a": "1"}, map[string]string{"a": "2"}, map[string]interface{}{}},
+		{"not desired", map[string]string{"a": "1", "b": "2"}, map[string]string{"a": "1"}, map[string]interface{}{"b": nil}},
+		{"nothing desired", map[string]string{"a": "1"}, nil, map[string]interface{}{"a": nil}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ownership.RemovedKeys(test.current, test.desired))
+		})
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  map[string]string
+		apply    map[string]interface{}
+		others   map[string]bool
+		expected map[string]bool
+	}{
+		{"new key", nil, map[string]interface{}{"a": "1"}, map[string]bool{"a": true}, map[string]bool{}},
+		{"same value", map[string]string{"a": "1"}, map[string]interface{}{"a": "1"}, map[string]bool{"a": true}, map[string]bool{}},
+		{"changed value not owned by others", map[string]string{"a": "1"}, map[string]interface{}{"a": "2"}, nil, map[string]bool{}},
+		{"changed value owned by others", map[string]string{"a": "1"}, map[string]interface{}{"a": "2"}, map[string]bool{"a": true}, map[string]bool{"a": true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ownership.Conflicts(test.current, test.apply, test.others))
+		})
+	}
+}